@@ -1,22 +1,65 @@
 package main
 
 import (
-	"time"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/teguhkurnia/redis-like/internal/config"
 	"github.com/teguhkurnia/redis-like/internal/server"
 	"github.com/teguhkurnia/redis-like/internal/store"
 )
 
 func main() {
+	configFile := flag.String("config", "", "a redis.conf-style config file")
+	// Every setting can also be given as a flag, which overrides the file.
+	type override struct{ name, value string }
+	var overrides []override
+	for _, name := range config.Names() {
+		flag.Func(name, config.Usage(name), func(value string) error {
+			overrides = append(overrides, override{name, value})
+			return nil
+		})
+	}
+	flag.Parse()
+
+	cfg := config.Default()
+	if *configFile != "" {
+		var err error
+		if cfg, err = config.Load(*configFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	for _, o := range overrides {
+		if err := cfg.Set(o.name, o.value); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid value %q for -%s: %v\n", o.value, o.name, err)
+			os.Exit(1)
+		}
+	}
+
 	store := store.NewStore()
-	server := server.NewServer(":8080", store)
+	srv := server.NewServer(cfg, store)
 
-	ticker := time.NewTicker(1 * time.Second)
+	// SIGINT and SIGTERM shut down like SHUTDOWN, so the AOF is synced
+	// before the process exits. Like Redis, the server keeps running if the
+	// snapshot cannot be saved.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
-		for range ticker.C {
-			store.ClearExpired()
+		for sig := range signals {
+			fmt.Printf("🛑 Received %s, shutting down\n", sig)
+			ctx, cancel := context.WithTimeout(context.Background(), srv.Config().ShutdownTimeout)
+			err := srv.Stop(ctx)
+			cancel()
+			if err != nil {
+				fmt.Printf("Errors trying to shut down the server: %v\n", err)
+			}
 		}
 	}()
 
-	server.Start()
+	srv.Start()
 }
@@ -0,0 +1,534 @@
+// Package config holds the server settings. They are read from a
+// redis.conf-style file, overridden by command-line flags and, for most of
+// them, changed at runtime with CONFIG SET.
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/teguhkurnia/redis-like/internal/log"
+	"github.com/teguhkurnia/redis-like/internal/protocol/parser"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+var (
+	ErrUnknownOption = errors.New("unknown option")
+	ErrImmutable     = errors.New("can't set immutable config")
+)
+
+type Config struct {
+	Bind           string
+	Port           int
+	AppendFilename string
+	DBFilename     string
+	AppendFsync    log.FsyncPolicy
+	// MetricsAddr is where metrics are served over HTTP, if not empty.
+	MetricsAddr string
+	Save        []log.SaveRule
+	// The AOF is rewritten once it has grown by AutoAOFRewritePercentage
+	// since the last rewrite and is at least AutoAOFRewriteMinSize bytes.
+	AutoAOFRewritePercentage int64
+	AutoAOFRewriteMinSize    int64
+	MaxMemory                int64
+	MaxMemoryPolicy          store.EvictionPolicy
+	// Hz is how many times per second background tasks, such as the active
+	// expire cycle, run.
+	Hz int
+	// ShutdownTimeout is how long a shutdown waits for the commands in
+	// flight before closing their connections.
+	ShutdownTimeout time.Duration
+	// Commands running longer than SlowlogLogSlowerThan microseconds are
+	// added to the slow log, which keeps the last SlowlogMaxLen of them. A
+	// negative threshold disables the slow log.
+	SlowlogLogSlowerThan int64
+	SlowlogMaxLen        int
+
+	// File is the config file the settings were loaded from, which CONFIG
+	// REWRITE updates. It is empty when the server runs without one.
+	File string
+}
+
+// Default returns the settings used when neither the config file nor a flag
+// sets them.
+func Default() *Config {
+	return &Config{
+		Port:                     8080,
+		AppendFilename:           "server.log",
+		DBFilename:               "dump.rdb",
+		AppendFsync:              log.FsyncEverySec,
+		Save:                     log.DefaultSaveRules,
+		AutoAOFRewritePercentage: 100,
+		AutoAOFRewriteMinSize:    64 * 1024 * 1024,
+		MaxMemory:                0,
+		MaxMemoryPolicy:          store.NoEviction,
+		Hz:                       10,
+		ShutdownTimeout:          10 * time.Second,
+		SlowlogLogSlowerThan:     10000,
+		SlowlogMaxLen:            128,
+	}
+}
+
+// ListenAddr returns the address the server listens on.
+func (c *Config) ListenAddr() string {
+	return net.JoinHostPort(c.Bind, strconv.Itoa(c.Port))
+}
+
+// Clone returns a copy of c that can be changed independently.
+func (c *Config) Clone() *Config {
+	clone := *c
+	clone.Save = append([]log.SaveRule(nil), c.Save...)
+	return &clone
+}
+
+// param describes a setting as it is named and written in config files.
+type param struct {
+	name  string
+	usage string
+	// immutable settings can only be set at startup.
+	immutable bool
+	get       func(c *Config) string
+	set       func(c *Config, value string) error
+}
+
+// params lists every setting, in the order CONFIG REWRITE appends them.
+var params = []*param{
+	{
+		name:      "bind",
+		usage:     "the interface to listen on; all interfaces when empty",
+		immutable: true,
+		get:       func(c *Config) string { return c.Bind },
+		set: func(c *Config, value string) error {
+			c.Bind = value
+			return nil
+		},
+	},
+	{
+		name:      "port",
+		usage:     "the TCP port to listen on",
+		immutable: true,
+		get:       func(c *Config) string { return strconv.Itoa(c.Port) },
+		set: func(c *Config, value string) error {
+			port, err := parseInt(value, 0, 65535)
+			c.Port = int(port)
+			return err
+		},
+	},
+	{
+		name:      "appendfilename",
+		usage:     "the append-only file",
+		immutable: true,
+		get:       func(c *Config) string { return c.AppendFilename },
+		set: func(c *Config, value string) error {
+			if value == "" {
+				return errors.New("appendfilename can't be empty")
+			}
+			c.AppendFilename = value
+			return nil
+		},
+	},
+	{
+		name:      "dbfilename",
+		usage:     "the snapshot file",
+		immutable: true,
+		get:       func(c *Config) string { return c.DBFilename },
+		set: func(c *Config, value string) error {
+			if value == "" {
+				return errors.New("dbfilename can't be empty")
+			}
+			c.DBFilename = value
+			return nil
+		},
+	},
+	{
+		name:      "metrics-addr",
+		usage:     "the host:port serving /metrics, /healthz and /readyz over HTTP; none when empty",
+		immutable: true,
+		get:       func(c *Config) string { return c.MetricsAddr },
+		set: func(c *Config, value string) error {
+			if value != "" {
+				if _, _, err := net.SplitHostPort(value); err != nil {
+					return errors.New("argument must be a host:port address")
+				}
+			}
+			c.MetricsAddr = value
+			return nil
+		},
+	},
+	{
+		name:  "appendfsync",
+		usage: "how often the AOF is fsynced: always, everysec or no",
+		get:   func(c *Config) string { return c.AppendFsync.String() },
+		set: func(c *Config, value string) error {
+			policy, err := log.ParseFsyncPolicy(value)
+			if err != nil {
+				return errors.New("argument(s) must be one of the following: always, everysec, no")
+			}
+			c.AppendFsync = policy
+			return nil
+		},
+	},
+	{
+		name:  "save",
+		usage: `"<seconds> <changes> ..." pairs that trigger a snapshot; "" disables them`,
+		get:   func(c *Config) string { return formatSaveRules(c.Save) },
+		set: func(c *Config, value string) error {
+			rules, err := log.ParseSaveRules(value)
+			if err != nil {
+				return errors.New("Invalid save parameters")
+			}
+			c.Save = rules
+			return nil
+		},
+	},
+	{
+		name:  "auto-aof-rewrite-percentage",
+		usage: "AOF growth since the last rewrite that triggers a rewrite; 0 disables it",
+		get:   func(c *Config) string { return strconv.FormatInt(c.AutoAOFRewritePercentage, 10) },
+		set: func(c *Config, value string) error {
+			percentage, err := parseInt(value, 0, 1<<31-1)
+			c.AutoAOFRewritePercentage = percentage
+			return err
+		},
+	},
+	{
+		name:  "auto-aof-rewrite-min-size",
+		usage: "the smallest AOF that is rewritten automatically, e.g. 64mb",
+		get:   func(c *Config) string { return strconv.FormatInt(c.AutoAOFRewriteMinSize, 10) },
+		set: func(c *Config, value string) error {
+			size, err := ParseMemory(value)
+			c.AutoAOFRewriteMinSize = size
+			return err
+		},
+	},
+	{
+		name:  "maxmemory",
+		usage: "memory limit, e.g. 100mb; 0 means no limit",
+		get:   func(c *Config) string { return strconv.FormatInt(c.MaxMemory, 10) },
+		set: func(c *Config, value string) error {
+			limit, err := ParseMemory(value)
+			c.MaxMemory = limit
+			return err
+		},
+	},
+	{
+		name:  "maxmemory-policy",
+		usage: "how keys are evicted once maxmemory is reached",
+		get:   func(c *Config) string { return c.MaxMemoryPolicy.String() },
+		set: func(c *Config, value string) error {
+			policy, err := store.ParseEvictionPolicy(value)
+			if err != nil {
+				return errors.New("argument(s) must be one of the following: volatile-lru, allkeys-lru, volatile-lfu, allkeys-lfu, volatile-random, allkeys-random, volatile-ttl, noeviction")
+			}
+			c.MaxMemoryPolicy = policy
+			return nil
+		},
+	},
+	{
+		name:  "hz",
+		usage: "how many times per second background tasks run, from 1 to 500",
+		get:   func(c *Config) string { return strconv.Itoa(c.Hz) },
+		set: func(c *Config, value string) error {
+			hz, err := parseInt(value, 1, 500)
+			c.Hz = int(hz)
+			return err
+		},
+	},
+	{
+		name:  "shutdown-timeout",
+		usage: "seconds a shutdown waits for the commands in flight",
+		get:   func(c *Config) string { return strconv.FormatInt(int64(c.ShutdownTimeout/time.Second), 10) },
+		set: func(c *Config, value string) error {
+			seconds, err := parseInt(value, 0, 1<<31-1)
+			c.ShutdownTimeout = time.Duration(seconds) * time.Second
+			return err
+		},
+	},
+	{
+		name:  "slowlog-log-slower-than",
+		usage: "microseconds a command must run to be added to the slow log, negative to disable it",
+		get:   func(c *Config) string { return strconv.FormatInt(c.SlowlogLogSlowerThan, 10) },
+		set: func(c *Config, value string) error {
+			usec, err := parseInt(value, math.MinInt64, math.MaxInt64)
+			c.SlowlogLogSlowerThan = usec
+			return err
+		},
+	},
+	{
+		name:  "slowlog-max-len",
+		usage: "how many commands the slow log keeps",
+		get:   func(c *Config) string { return strconv.Itoa(c.SlowlogMaxLen) },
+		set: func(c *Config, value string) error {
+			n, err := parseInt(value, 0, 1<<31-1)
+			c.SlowlogMaxLen = int(n)
+			return err
+		},
+	},
+}
+
+func lookup(name string) (*param, bool) {
+	name = strings.ToLower(name)
+	for _, p := range params {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// Names returns the name of every setting.
+func Names() []string {
+	names := make([]string, len(params))
+	for i, p := range params {
+		names[i] = p.name
+	}
+	return names
+}
+
+// Usage describes the setting called name, for command-line help.
+func Usage(name string) string {
+	if p, found := lookup(name); found {
+		return p.usage
+	}
+	return ""
+}
+
+// IsImmutable reports whether the setting called name can only be set at
+// startup.
+func IsImmutable(name string) bool {
+	p, found := lookup(name)
+	return found && p.immutable
+}
+
+// Set changes the setting called name, parsing value as written in the
+// config file.
+func (c *Config) Set(name, value string) error {
+	p, found := lookup(name)
+	if !found {
+		return fmt.Errorf("%w '%s'", ErrUnknownOption, name)
+	}
+	return p.set(c, value)
+}
+
+// Get returns the settings whose name matches the glob-style pattern, by
+// name.
+func (c *Config) Get(pattern string) map[string]string {
+	pattern = strings.ToLower(pattern)
+	settings := make(map[string]string)
+	for _, p := range params {
+		if matched, _ := path.Match(pattern, p.name); matched {
+			settings[p.name] = p.get(c)
+		}
+	}
+	return settings
+}
+
+// Load reads the config file at filename on top of the defaults. Like
+// redis.conf, every line holds a setting name followed by its value, which
+// can be quoted, and lines starting with # are comments. Several save lines
+// add up.
+func Load(filename string) (*Config, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	c := Default()
+	c.File = filename
+	var saves []string
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		name, args, err := parseLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filename, n, err)
+		}
+		if name == "" {
+			continue
+		}
+		if name == "save" {
+			saves = append(saves, strings.Join(args, " "))
+			continue
+		}
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s:%d: wrong number of arguments for '%s'", filename, n, name)
+		}
+		if err := c.Set(name, args[0]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filename, n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if saves != nil {
+		if err := c.Set("save", strings.Join(saves, " ")); err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+	return c, nil
+}
+
+// parseLine splits a config file line into the lower-case setting name and
+// its arguments. Blank lines and comments have no name.
+func parseLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", nil, nil
+	}
+	words, err := parser.SplitArgs([]byte(line))
+	if err != nil {
+		return "", nil, err
+	}
+	args := make([]string, len(words)-1)
+	for i, word := range words[1:] {
+		args[i] = string(word)
+	}
+	return strings.ToLower(string(words[0])), args, nil
+}
+
+// Rewrite updates the config file c was loaded from to hold the current
+// settings. Lines of settings are replaced in place and comments are kept.
+// Settings missing from the file are appended unless they have their
+// default value.
+func (c *Config) Rewrite() error {
+	if c.File == "" {
+		return errors.New("The server is running without a config file")
+	}
+	content, err := os.ReadFile(c.File)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var lines []string
+	written := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+		name, _, err := parseLine(line)
+		p, found := lookup(name)
+		if err != nil || !found {
+			lines = append(lines, line)
+			continue
+		}
+		// Repeated settings, like several save lines, become one.
+		if !written[p.name] {
+			lines = append(lines, p.line(c))
+			written[p.name] = true
+		}
+	}
+	if len(lines) == 1 && lines[0] == "" {
+		lines = nil
+	}
+
+	defaults := Default()
+	generated := false
+	for _, p := range params {
+		if written[p.name] || p.get(c) == p.get(defaults) {
+			continue
+		}
+		if !generated {
+			lines = append(lines, "# Generated by CONFIG REWRITE")
+			generated = true
+		}
+		lines = append(lines, p.line(c))
+	}
+
+	tmpFile := c.File + ".tmp"
+	if err := os.WriteFile(tmpFile, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, c.File)
+}
+
+// line returns the config file line setting p to its value in c.
+func (p *param) line(c *Config) string {
+	value := p.get(c)
+	if p.name == "save" && value != "" {
+		// The rules are several arguments.
+		return p.name + " " + value
+	}
+	return p.name + " " + quote(value)
+}
+
+// quote returns s as a config file argument, quoted when it is empty or
+// contains characters SplitArgs would not read back as they are.
+func quote(s string) string {
+	if s != "" && !strings.ContainsFunc(s, func(r rune) bool {
+		return r <= ' ' || r == '"' || r == '\'' || r == '\\' || r >= 0x7f
+	}) {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '\\' || ch == '"':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case ch == '\n':
+			b.WriteString(`\n`)
+		case ch == '\r':
+			b.WriteString(`\r`)
+		case ch == '\t':
+			b.WriteString(`\t`)
+		case ch < ' ' || ch >= 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, ch)
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func formatSaveRules(rules []log.SaveRule) string {
+	fields := make([]string, 0, 2*len(rules))
+	for _, rule := range rules {
+		fields = append(fields, strconv.FormatInt(rule.Seconds, 10), strconv.FormatInt(rule.Changes, 10))
+	}
+	return strings.Join(fields, " ")
+}
+
+func parseInt(value string, min, max int64) (int64, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errors.New("argument couldn't be parsed into an integer")
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+	}
+	return n, nil
+}
+
+// ParseMemory parses a byte count with an optional unit, accepting the same
+// forms as redis.conf: 1k is 1000 bytes and 1kb is 1024.
+func ParseMemory(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		bytes  int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+	lower := strings.ToLower(s)
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower = strings.TrimSuffix(lower, unit.suffix)
+			multiplier = unit.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("argument must be a memory value")
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teguhkurnia/redis-like/internal/log"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+func writeFile(t *testing.T, content string) string {
+	filename := filepath.Join(t.TempDir(), "redis.conf")
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0644))
+	return filename
+}
+
+func TestLoad(t *testing.T) {
+	filename := writeFile(t, `# A comment
+port 7000
+bind 127.0.0.1
+
+appendfilename "my log.aof"
+MAXMEMORY 100mb
+maxmemory-policy allkeys-lru
+save 60 10
+save 300 1
+hz 20
+shutdown-timeout 3
+slowlog-log-slower-than -1
+slowlog-max-len 16
+metrics-addr 127.0.0.1:9121
+`)
+	c, err := Load(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:7000", c.ListenAddr())
+	assert.Equal(t, "my log.aof", c.AppendFilename)
+	assert.Equal(t, "dump.rdb", c.DBFilename, "settings missing from the file keep their default")
+	assert.Equal(t, int64(100<<20), c.MaxMemory)
+	assert.Equal(t, store.AllKeysLRU, c.MaxMemoryPolicy)
+	assert.Equal(t, []log.SaveRule{{Seconds: 60, Changes: 10}, {Seconds: 300, Changes: 1}}, c.Save)
+	assert.Equal(t, 20, c.Hz)
+	assert.Equal(t, 3*time.Second, c.ShutdownTimeout)
+	assert.Equal(t, int64(-1), c.SlowlogLogSlowerThan)
+	assert.Equal(t, 16, c.SlowlogMaxLen)
+	assert.Equal(t, "127.0.0.1:9121", c.MetricsAddr)
+	assert.Equal(t, filename, c.File)
+
+	c, err = Load(writeFile(t, "save \"\"\n"))
+	require.NoError(t, err)
+	assert.Empty(t, c.Save)
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, content := range []string{
+		"port abc\n",
+		"port 70000\n",
+		"nosuchoption yes\n",
+		"port 1 2\n",
+		"appendfsync sometimes\n",
+		"save 60\n",
+		"slowlog-max-len -1\n",
+		"metrics-addr 9121\n",
+		"bind \"unterminated\n",
+	} {
+		_, err := Load(writeFile(t, content))
+		assert.Error(t, err, content)
+	}
+	_, err := Load(filepath.Join(t.TempDir(), "missing.conf"))
+	assert.Error(t, err)
+}
+
+func TestGetAndSet(t *testing.T) {
+	c := Default()
+	require.NoError(t, c.Set("maxmemory", "1kb"))
+	require.NoError(t, c.Set("appendfsync", "always"))
+	assert.Equal(t, map[string]string{"maxmemory": "1024", "maxmemory-policy": "noeviction"}, c.Get("maxmemory*"))
+	assert.Equal(t, map[string]string{"appendfsync": "always"}, c.Get("APPENDFSYNC"))
+	assert.Equal(t, "3600 1 300 100 60 10000", c.Get("save")["save"])
+	assert.Len(t, c.Get("*"), len(Names()))
+
+	assert.ErrorIs(t, c.Set("nosuchoption", "1"), ErrUnknownOption)
+	assert.EqualError(t, c.Set("hz", "0"), "argument must be between 1 and 500 inclusive")
+	assert.True(t, IsImmutable("port"))
+	assert.False(t, IsImmutable("maxmemory"))
+
+	clone := c.Clone()
+	clone.Save[0].Seconds = 1
+	assert.Equal(t, int64(3600), c.Save[0].Seconds)
+}
+
+func TestRewrite(t *testing.T) {
+	filename := writeFile(t, `# Keep this comment
+port 7000
+save 60 10
+save 300 1
+maxmemory 1mb
+`)
+	c, err := Load(filename)
+	require.NoError(t, err)
+	require.NoError(t, c.Set("maxmemory", "2mb"))
+	require.NoError(t, c.Set("maxmemory-policy", "volatile-ttl"))
+	require.NoError(t, c.Set("appendfilename", "a file"))
+	require.NoError(t, c.Rewrite())
+
+	content, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, `# Keep this comment
+port 7000
+save 60 10 300 1
+maxmemory 2097152
+# Generated by CONFIG REWRITE
+appendfilename "a file"
+maxmemory-policy volatile-ttl
+`, string(content))
+
+	reloaded, err := Load(filename)
+	require.NoError(t, err)
+	reloaded.File = ""
+	c.File = ""
+	assert.Equal(t, c, reloaded)
+
+	assert.Error(t, Default().Rewrite(), "no config file")
+}
+
+func TestParseMemory(t *testing.T) {
+	tests := map[string]int64{
+		"0":            0,
+		"100":          100,
+		"1k":           1000,
+		"1kb":          1024,
+		"2MB":          2 << 20,
+		"1g":           1000 * 1000 * 1000,
+		"512b":         512,
+		"10gb":         10 << 30,
+		"100mb":        100 << 20,
+		"8589934591gb": 8589934591 << 30,
+	}
+	for input, want := range tests {
+		got, err := ParseMemory(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+	for _, input := range []string{"", "mb", "-1", "1tb", "abc", "99999999999gb", "9223372036854775807k"} {
+		_, err := ParseMemory(input)
+		assert.Error(t, err, input)
+	}
+}
@@ -2,71 +2,597 @@ package log
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/teguhkurnia/redis-like/internal/protocol/commands"
+	"github.com/teguhkurnia/redis-like/internal/protocol/parser"
+	"github.com/teguhkurnia/redis-like/internal/snapshot"
+	"github.com/teguhkurnia/redis-like/internal/store"
 )
 
+// The AOF starts with a header line identifying the format version. Every
+// entry after the header is a write command encoded as a RESP array. When the
+// header names a base snapshot, the entries are the writes applied after it.
+const (
+	headerPrefix   = "REDIS-LIKE-AOF "
+	currentVersion = 1
+)
+
+// FsyncPolicy controls how often the AOF is fsynced to disk, mirroring the
+// appendfsync setting of Redis.
+type FsyncPolicy int
+
+const (
+	// FsyncAlways fsyncs after every batch of writes before replying.
+	FsyncAlways FsyncPolicy = iota
+	// FsyncEverySec fsyncs once per second in the background.
+	FsyncEverySec
+	// FsyncNo leaves flushing to the operating system.
+	FsyncNo
+)
+
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch strings.ToLower(s) {
+	case "always":
+		return FsyncAlways, nil
+	case "everysec":
+		return FsyncEverySec, nil
+	case "no":
+		return FsyncNo, nil
+	}
+	return 0, fmt.Errorf("invalid appendfsync policy: %s", s)
+}
+
+func (p FsyncPolicy) String() string {
+	switch p {
+	case FsyncAlways:
+		return "always"
+	case FsyncEverySec:
+		return "everysec"
+	case FsyncNo:
+		return "no"
+	}
+	return "unknown"
+}
+
+// maxBatch bounds how many queued requests the writer handles before it
+// flushes, so a steady stream of writes still reaches the file promptly.
+const maxBatch = 1024
+
+// request is a unit of work for the writer goroutine: either an encoded
+// entry to append or a function to run once everything queued before it
+// has been written.
+type request struct {
+	data []byte
+	fn   func() error
+	done chan error
+}
+
 type Log struct {
-	logMutex *sync.Mutex
-	logFile  string
+	logFile      string
+	snapshotFile string
+	// fsync holds a FsyncPolicy, which can be changed while the log is open.
+	fsync atomic.Int32
+	store *store.Store
+
+	// A rewrite is started automatically once the file has grown by
+	// autoRewritePercentage since the last rewrite and is at least
+	// autoRewriteMinSize bytes.
+	autoRewritePercentage atomic.Int64
+	autoRewriteMinSize    atomic.Int64
+	autoRewriteScheduled  atomic.Bool
+
+	// A background save is started when any rule's number of changes has
+	// happened within its number of seconds since the last save.
+	saveRules     atomic.Pointer[[]SaveRule]
+	saveScheduled atomic.Bool
+	dirty         atomic.Int64
+	lastSave      atomic.Int64
+
+	// applyMu is held while a write is applied to the store and its entry is
+	// queued, so entries reach the file in the order they were applied.
+	applyMu sync.Mutex
+
+	// mu guards closed; sends on requests hold it for reading.
+	mu       sync.RWMutex
+	closed   bool
+	requests chan *request
+	done     chan struct{}
+
+	// busy is the error returned while a rewrite or save is running. It is
+	// guarded by applyMu.
+	busy error
+
+	// size and baseSize track the file size now and after the last rewrite.
+	size     atomic.Int64
+	baseSize atomic.Int64
+
+	// The outcome of the last write, rewrite and save, reported by Stats.
+	lastWriteFailed   atomic.Bool
+	lastRewriteFailed atomic.Bool
+	lastSaveFailed    atomic.Bool
+	// lastFsync is when the AOF was last fsynced, in Unix milliseconds.
+	lastFsync atomic.Int64
+
+	// Owned by the writer goroutine. rewriteBuf collects entries written
+	// while a rewrite is in progress.
+	file       *os.File
+	writer     *bufio.Writer
+	rewriteBuf *bytes.Buffer
 }
 
-func NewLog(logFile string) *Log {
-	// Ensure the log file exists
+func NewLog(logFile, snapshotFile string, fsync FsyncPolicy, store *store.Store) *Log {
+	// Ensure the log file exists. An existing snapshot becomes its base, so
+	// a dump copied into place is loaded on the next start.
 	if _, err := os.Stat(logFile); os.IsNotExist(err) {
+		baseID, _ := snapshot.ReadID(snapshotFile)
 		file, err := os.Create(logFile)
 		if err != nil {
 			panic("Error creating log file: " + err.Error())
 		}
+		if _, err := file.WriteString(header(baseID)); err != nil {
+			panic("Error writing log file header: " + err.Error())
+		}
 		file.Close()
 	}
 
-	return &Log{
-		logFile:  logFile,
-		logMutex: &sync.Mutex{},
+	l := &Log{
+		logFile:      logFile,
+		snapshotFile: snapshotFile,
+		store:        store,
+		requests:     make(chan *request, maxBatch),
+		done:         make(chan struct{}),
+	}
+	l.SetFsync(fsync)
+	l.SetAutoRewrite(100, 64*1024*1024)
+	l.SetSaveRules(DefaultSaveRules)
+	if err := l.reopen(); err != nil {
+		panic("Error opening log file: " + err.Error())
 	}
+	l.lastSave.Store(time.Now().Unix())
+	go l.run()
+
+	return l
 }
 
-func (Store *Log) StoreWriteCommandToLog(cmd *commands.Command) {
-	logEntry, err := cmd.ToLog()
+func header(baseID string) string {
+	if baseID == "" {
+		return fmt.Sprintf("%s%d\r\n", headerPrefix, currentVersion)
+	}
+	return fmt.Sprintf("%s%d base=%s\r\n", headerPrefix, currentVersion, baseID)
+}
+
+// parseHeader returns the format version and base snapshot id of a header.
+func parseHeader(line string) (int, string, error) {
+	fields := strings.Fields(strings.TrimPrefix(line, headerPrefix))
+	if len(fields) == 0 {
+		return 0, "", fmt.Errorf("invalid log file header: %q", line)
+	}
+	version, err := strconv.Atoi(fields[0])
 	if err != nil {
-		fmt.Printf("Error converting command to log entry: %v\n", err)
-		return
+		return 0, "", fmt.Errorf("invalid log file header: %q", line)
 	}
+	var baseID string
+	for _, field := range fields[1:] {
+		if id, ok := strings.CutPrefix(field, "base="); ok {
+			baseID = id
+		}
+	}
+	return version, baseID, nil
+}
+
+// Apply runs apply and queues the commands it returns for the AOF before any
+// other write can be applied. With appendfsync always it waits until the
+// entries are on disk before returning, so the reply is only sent after.
+func (l *Log) Apply(apply func() []*commands.Command) {
+	l.applyMu.Lock()
+	cmds := apply()
+	l.dirty.Add(int64(len(cmds)))
+	var pending []<-chan error
+	for _, cmd := range cmds {
+		done, err := l.StoreWriteCommandToLog(cmd)
+		if err != nil {
+			fmt.Printf("Error writing command to log: %v\n", err)
+			continue
+		}
+		if done != nil {
+			pending = append(pending, done)
+		}
+	}
+	l.applyMu.Unlock()
+
+	for _, done := range pending {
+		if err := <-done; err != nil {
+			fmt.Printf("Error writing command to log: %v\n", err)
+		}
+	}
+}
 
-	Store.appendToLogFile(logEntry)
+// Fsync returns the current fsync policy.
+func (l *Log) Fsync() FsyncPolicy {
+	return FsyncPolicy(l.fsync.Load())
 }
 
-func (Store *Log) appendToLogFile(logEntry string) {
-	Store.logMutex.Lock()
-	defer Store.logMutex.Unlock()
-	file, err := os.OpenFile(Store.logFile, os.O_APPEND|os.O_WRONLY, 0644)
+// SetFsync changes the fsync policy, starting with the next write.
+func (l *Log) SetFsync(policy FsyncPolicy) {
+	l.fsync.Store(int32(policy))
+}
+
+// SetAutoRewrite sets when the log is rewritten automatically: once it has
+// grown by percentage since the last rewrite and is at least minSize bytes.
+// A percentage of 0 disables automatic rewrites.
+func (l *Log) SetAutoRewrite(percentage, minSize int64) {
+	l.autoRewritePercentage.Store(percentage)
+	l.autoRewriteMinSize.Store(minSize)
+}
+
+// Stats describes the state of the AOF and snapshots.
+type Stats struct {
+	RewriteInProgress bool
+	SaveInProgress    bool
+	// The last write, rewrite and save succeeded, or none happened yet.
+	LastWriteOK   bool
+	LastRewriteOK bool
+	LastSaveOK    bool
+	// CurrentSize and BaseSize are the size of the AOF now and after the
+	// last rewrite or save.
+	CurrentSize int64
+	BaseSize    int64
+	// ChangesSinceLastSave counts the writes since the last snapshot.
+	ChangesSinceLastSave int64
+	LastSave             time.Time
+	// LastFsync is when the AOF was last fsynced, or the zero time if it
+	// has not been yet.
+	LastFsync time.Time
+}
+
+func (l *Log) Stats() Stats {
+	l.applyMu.Lock()
+	busy := l.busy
+	l.applyMu.Unlock()
+	return Stats{
+		RewriteInProgress:    busy == ErrRewriteInProgress,
+		SaveInProgress:       busy == ErrSaveInProgress,
+		LastWriteOK:          !l.lastWriteFailed.Load(),
+		LastRewriteOK:        !l.lastRewriteFailed.Load(),
+		LastSaveOK:           !l.lastSaveFailed.Load(),
+		CurrentSize:          l.size.Load(),
+		BaseSize:             l.baseSize.Load(),
+		ChangesSinceLastSave: l.dirty.Load(),
+		LastSave:             l.LastSave(),
+		LastFsync:            l.lastFsyncTime(),
+	}
+}
+
+func (l *Log) lastFsyncTime() time.Time {
+	if at := l.lastFsync.Load(); at != 0 {
+		return time.UnixMilli(at)
+	}
+	return time.Time{}
+}
+
+// StoreWriteCommandToLog queues cmd for the writer goroutine. When the fsync
+// policy is always, the returned channel reports once the entry is synced.
+func (l *Log) StoreWriteCommandToLog(cmd *commands.Command) (<-chan error, error) {
+	logEntry, err := cmd.ToLog()
 	if err != nil {
-		fmt.Printf("Error opening log file: %v\n", err)
-		return
+		return nil, fmt.Errorf("error converting command to log entry: %w", err)
 	}
-	defer file.Close()
 
-	if _, err := file.WriteString(logEntry + "\n"); err != nil {
+	req := &request{data: logEntry}
+	if l.Fsync() == FsyncAlways {
+		req.done = make(chan error, 1)
+	}
+	if err := l.send(req); err != nil {
+		return nil, err
+	}
+	return req.done, nil
+}
+
+// Sync blocks until every queued entry has been written and fsynced.
+func (l *Log) Sync() error {
+	return l.exec(l.sync)
+}
+
+// Close flushes and fsyncs pending entries and stops the writer goroutine.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.requests)
+	l.mu.Unlock()
+
+	<-l.done
+	return nil
+}
+
+// exec runs fn on the writer goroutine after all previously queued entries
+// have been flushed, and waits for its result.
+func (l *Log) exec(fn func() error) error {
+	req := &request{fn: fn, done: make(chan error, 1)}
+	if err := l.send(req); err != nil {
+		return err
+	}
+	return <-req.done
+}
+
+func (l *Log) send(req *request) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return fmt.Errorf("log is closed")
+	}
+	l.requests <- req
+	return nil
+}
+
+// run is the single writer goroutine. It batches queued entries into the
+// buffered writer, flushes once per batch and fsyncs according to the policy.
+func (l *Log) run() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case req, ok := <-l.requests:
+			if !ok {
+				l.shutdown()
+				return
+			}
+			batch := []*request{req}
+		drain:
+			for len(batch) < maxBatch {
+				select {
+				case req, ok := <-l.requests:
+					if !ok {
+						l.process(batch)
+						l.shutdown()
+						return
+					}
+					batch = append(batch, req)
+				default:
+					break drain
+				}
+			}
+			l.process(batch)
+		case <-ticker.C:
+			if l.Fsync() == FsyncEverySec {
+				err := l.sync()
+				l.lastWriteFailed.Store(err != nil)
+				if err != nil {
+					fmt.Printf("Error syncing log file: %v\n", err)
+				}
+			}
+			l.checkSaveRules()
+		}
+	}
+}
+
+func (l *Log) process(batch []*request) {
+	var waiting []*request
+	// pending counts the bytes written since the last flush.
+	var pending int64
+	var err error
+	for _, req := range batch {
+		if req.fn != nil {
+			l.finish(waiting, pending, err)
+			waiting, pending, err = waiting[:0], 0, nil
+			req.done <- req.fn()
+			continue
+		}
+		if err == nil {
+			var n int
+			n, err = l.writer.Write(req.data)
+			pending += int64(n)
+		}
+		if l.rewriteBuf != nil {
+			l.rewriteBuf.Write(req.data)
+		}
+		if req.done != nil {
+			waiting = append(waiting, req)
+		}
+	}
+	l.finish(waiting, pending, err)
+
+	if l.shouldAutoRewrite() && l.autoRewriteScheduled.CompareAndSwap(false, true) {
+		go func() {
+			defer l.autoRewriteScheduled.Store(false)
+			if err := l.BGRewrite(); err == nil {
+				fmt.Printf("Starting automatic rewrite of log file (%d bytes)\n", l.size.Load())
+			}
+		}()
+	}
+}
+
+// finish flushes the pending bytes written so far, fsyncs them if the policy
+// asks for it and reports the outcome to the requests waiting on them.
+func (l *Log) finish(waiting []*request, pending int64, err error) {
+	if err == nil {
+		err = l.writer.Flush()
+	}
+	if err != nil {
+		l.discard()
+	} else {
+		l.size.Add(pending)
+		if l.Fsync() == FsyncAlways {
+			err = l.syncFile()
+		}
+	}
+	l.lastWriteFailed.Store(err != nil)
+	for _, req := range waiting {
+		req.done <- err
+	}
+	if err != nil {
 		fmt.Printf("Error writing to log file: %v\n", err)
-		return
 	}
 }
 
-func (Store *Log) LoadCommandsFromLog() ([]*commands.Command, error) {
-	file, err := os.Open(Store.logFile)
+// discard drops the entries of a failed write, so the next batch retries on
+// a clean file. bufio.Writer fails every write once one has failed, so it
+// is reset, and like in Redis the part of the entries that reached the file
+// is truncated, so later entries do not follow a partial one.
+func (l *Log) discard() {
+	l.writer.Reset(l.file)
+	if err := l.file.Truncate(l.size.Load()); err != nil {
+		fmt.Printf("Error truncating log file after a failed write: %v\n", err)
+	}
+}
+
+func (l *Log) sync() error {
+	if err := l.writer.Flush(); err != nil {
+		return err
+	}
+	return l.syncFile()
+}
+
+func (l *Log) syncFile() error {
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.lastFsync.Store(time.Now().UnixMilli())
+	return nil
+}
+
+func (l *Log) shutdown() {
+	if err := l.sync(); err != nil {
+		fmt.Printf("Error syncing log file: %v\n", err)
+	}
+	l.file.Close()
+}
+
+// reopen points the writer at the current log file, which may have been
+// replaced on disk.
+func (l *Log) reopen() error {
+	file, err := os.OpenFile(l.logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if l.file != nil {
+		if err := l.sync(); err != nil {
+			file.Close()
+			return err
+		}
+		l.file.Close()
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.writer = bufio.NewWriter(file)
+	l.size.Store(info.Size())
+	l.baseSize.Store(info.Size())
+	return nil
+}
+
+// LoadCommandsFromLog reads every command stored in the log file. If the log
+// is based on a snapshot, the snapshot is restored into the store first and
+// only the commands applied after it are returned. Legacy line-based logs
+// are migrated to the RESP format on the first load.
+func (l *Log) LoadCommandsFromLog() ([]*commands.Command, error) {
+	file, err := os.Open(l.logFile)
 	if err != nil {
 		return nil, fmt.Errorf("error opening log file: %w", err)
 	}
 	defer file.Close()
 
+	counter := &countingReader{r: file}
+	reader := bufio.NewReader(counter)
+
+	first, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading log file: %w", err)
+	}
+	if first == "" {
+		// Empty file, e.g. created by an older version before any writes.
+		if err := l.writeFile("", nil); err != nil {
+			return nil, err
+		}
+		return nil, l.exec(l.reopen)
+	}
+
+	if !strings.HasPrefix(first, headerPrefix) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("error reading log file: %w", err)
+		}
+		return l.migrateLegacy(file)
+	}
+
+	version, baseID, err := parseHeader(first)
+	if err != nil {
+		return nil, err
+	}
+	if version > currentVersion {
+		return nil, fmt.Errorf("unsupported log file version %d", version)
+	}
+	if baseID != "" {
+		if err := l.loadSnapshot(baseID); err != nil {
+			return nil, err
+		}
+	}
+
+	var cmds []*commands.Command
+	validOffset := counter.n - int64(reader.Buffered())
+	for {
+		value, err := parser.ParseNextValue(reader)
+		offset := counter.n - int64(reader.Buffered())
+		if err != nil {
+			if err == io.EOF && offset == validOffset {
+				break
+			}
+			if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+				// The last entry was only partially written, most likely
+				// because the process died mid-write. Drop it.
+				fmt.Printf("Truncating incomplete entry at offset %d in log file\n", validOffset)
+				if err := os.Truncate(l.logFile, validOffset); err != nil {
+					return nil, fmt.Errorf("error truncating log file: %w", err)
+				}
+				l.size.Store(validOffset)
+				if l.baseSize.Load() > validOffset {
+					l.baseSize.Store(validOffset)
+				}
+				break
+			}
+			return nil, fmt.Errorf("error parsing log file at offset %d: %w", validOffset, err)
+		}
+		validOffset = offset
+
+		cmd, err := value.ToCommand()
+		if err != nil {
+			fmt.Printf("Error parsing command from log: %v\n", err)
+			continue
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	return cmds, nil
+}
+
+// migrateLegacy reads a log written in the old space-separated format and
+// rewrites it in the RESP format so the migration only happens once.
+func (l *Log) migrateLegacy(file *os.File) ([]*commands.Command, error) {
 	var cmds []*commands.Command
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
 		cmd, err := commands.FromLog(line)
 		if err != nil {
 			fmt.Printf("Error parsing command from log: %v\n", err)
@@ -79,5 +605,61 @@ func (Store *Log) LoadCommandsFromLog() ([]*commands.Command, error) {
 		return nil, fmt.Errorf("error reading log file: %w", err)
 	}
 
+	if err := l.writeFile("", cmds); err != nil {
+		return nil, err
+	}
+	if err := l.exec(l.reopen); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Migrated %d commands from legacy log format\n", len(cmds))
+
 	return cmds, nil
 }
+
+// writeFile atomically replaces the log file with a header followed by cmds.
+func (l *Log) writeFile(baseID string, cmds []*commands.Command) error {
+	var buf bytes.Buffer
+	buf.WriteString(header(baseID))
+	for _, cmd := range cmds {
+		entry, err := cmd.ToLog()
+		if err != nil {
+			return fmt.Errorf("error encoding command: %w", err)
+		}
+		buf.Write(entry)
+	}
+
+	tmpFile := l.logFile + ".tmp"
+	file, err := os.OpenFile(tmpFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error creating log file: %w", err)
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing log file: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("error syncing log file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error closing log file: %w", err)
+	}
+
+	if err := os.Rename(tmpFile, l.logFile); err != nil {
+		return fmt.Errorf("error replacing log file: %w", err)
+	}
+	return nil
+}
+
+// countingReader tracks how many bytes have been read from the file so the
+// loader knows where the last complete entry ended.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,325 @@
+package log
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teguhkurnia/redis-like/internal/protocol/commands"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+func appendCommand(t *testing.T, l *Log, name string, args ...string) {
+	t.Helper()
+	cmd := &commands.Command{Name: name}
+	for _, arg := range args {
+		cmd.Args = append(cmd.Args, []byte(arg))
+	}
+	_, err := l.StoreWriteCommandToLog(cmd)
+	require.NoError(t, err)
+}
+
+func TestLogRoundTripBinarySafe(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "server.log")
+	l := NewLog(logFile, filepath.Join(filepath.Dir(logFile), "dump.rdb"), FsyncNo, nil)
+	defer l.Close()
+
+	values := [][]byte{
+		[]byte("hello world"),
+		[]byte("line1\r\nline2"),
+		{0x00, 0xff, '\n', ' '},
+		{},
+	}
+	for _, v := range values {
+		appendCommand(t, l, "SET", "key", string(v))
+	}
+	require.NoError(t, l.Sync())
+
+	cmds, err := l.LoadCommandsFromLog()
+	require.NoError(t, err)
+	require.Len(t, cmds, len(values))
+	for i, cmd := range cmds {
+		assert.Equal(t, "SET", cmd.Name)
+		assert.Equal(t, "key", string(cmd.Args[0]))
+		assert.Equal(t, values[i], cmd.Args[1])
+	}
+}
+
+func TestLogTruncatesIncompleteEntry(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "server.log")
+	l := NewLog(logFile, filepath.Join(filepath.Dir(logFile), "dump.rdb"), FsyncNo, nil)
+	appendCommand(t, l, "SET", "a", "1")
+	require.NoError(t, l.Close())
+
+	file, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteString("*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$5\r\nab")
+	require.NoError(t, err)
+	file.Close()
+
+	l = NewLog(logFile, filepath.Join(filepath.Dir(logFile), "dump.rdb"), FsyncNo, nil)
+	defer l.Close()
+	cmds, err := l.LoadCommandsFromLog()
+	require.NoError(t, err)
+	require.Len(t, cmds, 1)
+
+	data, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(data), "$1\r\n1\r\n"))
+	assert.Equal(t, int64(len(data)), l.Stats().CurrentSize)
+}
+
+func TestLogMigratesLegacyFormat(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "server.log")
+	require.NoError(t, os.WriteFile(logFile, []byte("SET a 1\nLPUSH list x y\n"), 0644))
+
+	l := NewLog(logFile, filepath.Join(filepath.Dir(logFile), "dump.rdb"), FsyncNo, nil)
+	defer l.Close()
+	cmds, err := l.LoadCommandsFromLog()
+	require.NoError(t, err)
+	require.Len(t, cmds, 2)
+	assert.Equal(t, "LPUSH", cmds[1].Name)
+	assert.Equal(t, [][]byte{[]byte("list"), []byte("x"), []byte("y")}, cmds[1].Args)
+
+	data, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), header("")))
+
+	// New entries go to the migrated file, not the replaced one.
+	appendCommand(t, l, "SET", "b", "2")
+	require.NoError(t, l.Sync())
+
+	cmds, err = l.LoadCommandsFromLog()
+	require.NoError(t, err)
+	assert.Len(t, cmds, 3)
+}
+
+func TestLogApplyPreservesOrder(t *testing.T) {
+	for _, policy := range []FsyncPolicy{FsyncAlways, FsyncEverySec, FsyncNo} {
+		t.Run(policy.String(), func(t *testing.T) {
+			logFile := filepath.Join(t.TempDir(), "server.log")
+			l := NewLog(logFile, filepath.Join(filepath.Dir(logFile), "dump.rdb"), policy, nil)
+
+			var applied []string
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					value := strconv.Itoa(i)
+					cmd := &commands.Command{Name: "RPUSH", Args: [][]byte{[]byte("list"), []byte(value)}}
+					l.Apply(func() []*commands.Command {
+						applied = append(applied, value)
+						return []*commands.Command{cmd}
+					})
+				}(i)
+			}
+			wg.Wait()
+
+			// Writes that change nothing are not logged.
+			l.Apply(func() []*commands.Command {
+				return nil
+			})
+			require.NoError(t, l.Close())
+
+			reloaded := NewLog(logFile, filepath.Join(filepath.Dir(logFile), "dump.rdb"), policy, nil)
+			defer reloaded.Close()
+			cmds, err := reloaded.LoadCommandsFromLog()
+			require.NoError(t, err)
+			require.Len(t, cmds, len(applied))
+			for i, cmd := range cmds {
+				assert.Equal(t, applied[i], string(cmd.Args[1]))
+			}
+		})
+	}
+}
+
+func TestParseFsyncPolicy(t *testing.T) {
+	for _, policy := range []FsyncPolicy{FsyncAlways, FsyncEverySec, FsyncNo} {
+		parsed, err := ParseFsyncPolicy(policy.String())
+		require.NoError(t, err)
+		assert.Equal(t, policy, parsed)
+	}
+	_, err := ParseFsyncPolicy("sometimes")
+	assert.Error(t, err)
+}
+
+func TestLogBGRewrite(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "server.log")
+	s := store.NewStore()
+	l := NewLog(logFile, filepath.Join(filepath.Dir(logFile), "dump.rdb"), FsyncNo, s)
+	defer l.Close()
+
+	// History that the rewrite collapses into single commands.
+	for i := 0; i < 10; i++ {
+		appendCommand(t, l, "RPUSH", "list", strconv.Itoa(i))
+		s.RPush("list", []string{strconv.Itoa(i)})
+	}
+	s.Set("greeting", "hello world")
+	s.HSet("hash", "field", "value")
+	s.Expire("greeting", 60)
+
+	require.NoError(t, l.BGRewrite())
+	assert.ErrorIs(t, l.BGRewrite(), ErrRewriteInProgress)
+	// Writes applied after the snapshot are buffered and kept.
+	appendCommand(t, l, "SET", "after", "rewrite")
+	require.Eventually(t, func() bool {
+		l.applyMu.Lock()
+		defer l.applyMu.Unlock()
+		return l.busy == nil
+	}, time.Second, 10*time.Millisecond)
+
+	cmds, err := l.LoadCommandsFromLog()
+	require.NoError(t, err)
+
+	names := map[string]int{}
+	for _, cmd := range cmds {
+		names[cmd.Name]++
+	}
+	assert.Equal(t, map[string]int{"RPUSH": 1, "SET": 2, "HSET": 1, "PEXPIREAT": 1}, names)
+	assert.Equal(t, "after", string(cmds[len(cmds)-1].Args[0]))
+	for _, cmd := range cmds {
+		if cmd.Name == "RPUSH" {
+			assert.Len(t, cmd.Args, 11)
+		}
+	}
+
+	// The writer appends to the new file after the swap.
+	appendCommand(t, l, "DEL", "after")
+	require.NoError(t, l.Sync())
+	cmds, err = l.LoadCommandsFromLog()
+	require.NoError(t, err)
+	assert.Equal(t, "DEL", cmds[len(cmds)-1].Name)
+}
+
+func TestLogSaveAndReload(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "server.log")
+	snapshotFile := filepath.Join(dir, "dump.rdb")
+
+	s := store.NewStore()
+	l := NewLog(logFile, snapshotFile, FsyncNo, s)
+	for i := 0; i < 5; i++ {
+		appendCommand(t, l, "RPUSH", "list", strconv.Itoa(i))
+		s.RPush("list", []string{strconv.Itoa(i)})
+	}
+	require.NoError(t, l.Save())
+	require.NoError(t, l.BGSave())
+	require.Eventually(t, func() bool {
+		l.applyMu.Lock()
+		defer l.applyMu.Unlock()
+		return l.busy == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// Only writes after the snapshot remain in the log.
+	appendCommand(t, l, "RPUSH", "list", "tail")
+	require.NoError(t, l.Close())
+
+	restored := store.NewStore()
+	reloaded := NewLog(logFile, snapshotFile, FsyncNo, restored)
+	defer reloaded.Close()
+	cmds, err := reloaded.LoadCommandsFromLog()
+	require.NoError(t, err)
+	require.Len(t, cmds, 1)
+	assert.Equal(t, "tail", string(cmds[0].Args[1]))
+	list, err := restored.LRange("list", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0", "1", "2", "3", "4"}, list)
+}
+
+func TestLogFinishesInterruptedSave(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "server.log")
+	snapshotFile := filepath.Join(dir, "dump.rdb")
+
+	s := store.NewStore()
+	s.Set("key", "value")
+	l := NewLog(logFile, snapshotFile, FsyncNo, s)
+	require.NoError(t, l.Save())
+	require.NoError(t, l.Close())
+
+	// Simulate a crash after the log was swapped but before the snapshot
+	// was renamed into place.
+	require.NoError(t, os.Rename(snapshotFile, snapshotFile+".tmp"))
+
+	restored := store.NewStore()
+	reloaded := NewLog(logFile, snapshotFile, FsyncNo, restored)
+	defer reloaded.Close()
+	_, err := reloaded.LoadCommandsFromLog()
+	require.NoError(t, err)
+	value, _, _ := restored.Get("key")
+	assert.Equal(t, "value", value)
+	assert.FileExists(t, snapshotFile)
+}
+
+func TestLogStats(t *testing.T) {
+	dir := t.TempDir()
+	s := store.NewStore()
+	s.Set("key", "value")
+	l := NewLog(filepath.Join(dir, "server.log"), filepath.Join(dir, "missing", "dump.rdb"), FsyncAlways, s)
+	defer l.Close()
+
+	appendCommand(t, l, "SET", "key", "value")
+	require.NoError(t, l.Sync())
+	stats := l.Stats()
+	assert.True(t, stats.LastWriteOK)
+	assert.True(t, stats.LastSaveOK)
+	assert.False(t, stats.SaveInProgress)
+	assert.Positive(t, stats.CurrentSize)
+	assert.WithinDuration(t, time.Now(), stats.LastFsync, time.Second)
+
+	// The snapshot directory does not exist.
+	assert.Error(t, l.Save())
+	stats = l.Stats()
+	assert.False(t, stats.LastSaveOK)
+	assert.True(t, stats.LastRewriteOK)
+}
+
+// fullDisk writes the first bytes it is given to file, then fails like a
+// full disk.
+type fullDisk struct {
+	file *os.File
+}
+
+func (d fullDisk) Write(p []byte) (int, error) {
+	n, _ := d.file.Write(p[:min(len(p), 5)])
+	return n, syscall.ENOSPC
+}
+
+func TestLogRecoversFromFailedWrite(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "server.log")
+	l := NewLog(logFile, filepath.Join(filepath.Dir(logFile), "dump.rdb"), FsyncAlways, nil)
+	defer l.Close()
+	appendCommand(t, l, "SET", "a", "1")
+	require.NoError(t, l.Sync())
+	size := l.Stats().CurrentSize
+
+	require.NoError(t, l.exec(func() error {
+		l.writer = bufio.NewWriter(fullDisk{l.file})
+		return nil
+	}))
+	done, err := l.StoreWriteCommandToLog(&commands.Command{Name: "SET", Args: [][]byte{[]byte("b"), []byte("2")}})
+	require.NoError(t, err)
+	assert.ErrorIs(t, <-done, syscall.ENOSPC)
+	assert.Equal(t, size, l.Stats().CurrentSize)
+
+	// The next write goes through, without the partial entry before it.
+	done, err = l.StoreWriteCommandToLog(&commands.Command{Name: "SET", Args: [][]byte{[]byte("c"), []byte("3")}})
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+	info, err := os.Stat(logFile)
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), l.Stats().CurrentSize)
+	cmds, err := l.LoadCommandsFromLog()
+	require.NoError(t, err)
+	require.Len(t, cmds, 2)
+	assert.Equal(t, "c", string(cmds[1].Args[0]))
+}
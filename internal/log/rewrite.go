@@ -0,0 +1,228 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/teguhkurnia/redis-like/internal/protocol/commands"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+var (
+	ErrRewriteInProgress = errors.New("Background append only file rewriting already in progress")
+	ErrSaveInProgress    = errors.New("Background save already in progress")
+)
+
+// rewriteJob describes a background rewrite of the log file. Both AOF
+// rewrites and snapshots are rewrites: they differ only in what the new file
+// starts with.
+type rewriteJob struct {
+	// busy is returned to callers that try to start another job meanwhile.
+	busy error
+	// failed records whether the job failed, for Stats.
+	failed *atomic.Bool
+	// prepare writes the start of the new log to tmpFile from a snapshot of
+	// the store. Writes applied after the snapshot are appended to it.
+	prepare func(tmpFile string, data map[string]store.Data) error
+	// commit runs on the writer goroutine once the new log is in place.
+	// dirty is the number of changes that were included in the snapshot.
+	commit func(dirty int64) error
+}
+
+// BGRewrite compacts the log in the background, replacing it with the minimal
+// commands that recreate the current store contents.
+func (l *Log) BGRewrite() error {
+	_, err := l.startRewrite(&rewriteJob{
+		busy:   ErrRewriteInProgress,
+		failed: &l.lastRewriteFailed,
+		prepare: func(tmpFile string, data map[string]store.Data) error {
+			return writeRewrite(tmpFile, data)
+		},
+	})
+	return err
+}
+
+// startRewrite snapshots the store under the apply lock and starts buffering
+// every write applied afterwards, so the new file plus the buffer reproduce
+// the store exactly. The new file is swapped in atomically once complete, and
+// the returned channel reports the outcome.
+func (l *Log) startRewrite(job *rewriteJob) (<-chan error, error) {
+	l.applyMu.Lock()
+	defer l.applyMu.Unlock()
+	if l.busy != nil {
+		return nil, l.busy
+	}
+	if l.store == nil {
+		return nil, errors.New("no store to rewrite the log from")
+	}
+
+	data := l.store.Snapshot()
+	dirty := l.dirty.Load()
+	if err := l.send(&request{fn: l.beginRewrite, done: make(chan error, 1)}); err != nil {
+		return nil, err
+	}
+	l.busy = job.busy
+
+	done := make(chan error, 1)
+	go func() {
+		done <- l.rewrite(job, data, dirty)
+	}()
+	return done, nil
+}
+
+func (l *Log) rewrite(job *rewriteJob, data map[string]store.Data, dirty int64) error {
+	tmpFile := l.logFile + ".rewrite.tmp"
+	err := job.prepare(tmpFile, data)
+	if err == nil {
+		err = l.exec(func() error {
+			if err := l.finishRewrite(tmpFile); err != nil {
+				return err
+			}
+			if job.commit != nil {
+				return job.commit(dirty)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		fmt.Printf("Error rewriting log file: %v\n", err)
+		l.exec(l.abortRewrite)
+		os.Remove(tmpFile)
+	}
+	job.failed.Store(err != nil)
+
+	l.applyMu.Lock()
+	l.busy = nil
+	l.applyMu.Unlock()
+	return err
+}
+
+func (l *Log) beginRewrite() error {
+	l.rewriteBuf = &bytes.Buffer{}
+	return nil
+}
+
+func (l *Log) abortRewrite() error {
+	l.rewriteBuf = nil
+	return nil
+}
+
+// finishRewrite runs on the writer goroutine, so no entry can be written
+// between appending the buffered writes and swapping the files.
+func (l *Log) finishRewrite(tmpFile string) error {
+	file, err := os.OpenFile(tmpFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(l.rewriteBuf.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpFile, l.logFile); err != nil {
+		return err
+	}
+	l.rewriteBuf = nil
+	return l.reopen()
+}
+
+func (l *Log) shouldAutoRewrite() bool {
+	percentage := l.autoRewritePercentage.Load()
+	if l.rewriteBuf != nil || percentage <= 0 {
+		return false
+	}
+	size := l.size.Load()
+	if size < l.autoRewriteMinSize.Load() {
+		return false
+	}
+	base := max(l.baseSize.Load(), 1)
+	return (size-base)*100/base >= percentage
+}
+
+// writeRewrite writes a self-contained log holding the commands that
+// recreate data.
+func writeRewrite(path string, data map[string]store.Data) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	if _, err := writer.WriteString(header("")); err != nil {
+		return err
+	}
+	for key, value := range data {
+		for _, cmd := range commandsForKey(key, value) {
+			entry, err := cmd.ToLog()
+			if err != nil {
+				return err
+			}
+			if _, err := writer.Write(entry); err != nil {
+				return err
+			}
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// commandsForKey returns the minimal commands that recreate key: a single
+// command holding the whole value, followed by PEXPIREAT if it has a TTL.
+func commandsForKey(key string, data store.Data) []*commands.Command {
+	args := [][]byte{[]byte(key)}
+	var name string
+	switch value := data.Value.(type) {
+	case string:
+		name = "SET"
+		args = append(args, []byte(value))
+	case []string:
+		name = "RPUSH"
+		for _, item := range value {
+			args = append(args, []byte(item))
+		}
+	case map[string]string:
+		name = "HSET"
+		for field, val := range value {
+			args = append(args, []byte(field), []byte(val))
+		}
+	case map[string]struct{}:
+		name = "SADD"
+		for member := range value {
+			args = append(args, []byte(member))
+		}
+	case []store.SortedSet:
+		name = "ZADD"
+		for _, member := range value {
+			args = append(args, strconv.AppendFloat(nil, member.Score, 'g', -1, 64), []byte(member.Member))
+		}
+	default:
+		fmt.Printf("Skipping key %q with unsupported type %T during log rewrite\n", key, value)
+		return nil
+	}
+	if len(args) == 1 {
+		// Empty collections do not exist as keys.
+		return nil
+	}
+
+	cmds := []*commands.Command{{Name: name, Args: args}}
+	if data.TTL > 0 {
+		cmds = append(cmds, commands.PExpireAtCommand(key, time.UnixMilli(data.TTL)))
+	}
+	return cmds
+}
@@ -0,0 +1,172 @@
+package log
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/teguhkurnia/redis-like/internal/snapshot"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+// SaveRule triggers a background save once Changes writes have happened and
+// at least Seconds have passed since the last save.
+type SaveRule struct {
+	Seconds int64
+	Changes int64
+}
+
+// DefaultSaveRules match the defaults of Redis.
+var DefaultSaveRules = []SaveRule{
+	{Seconds: 3600, Changes: 1},
+	{Seconds: 300, Changes: 100},
+	{Seconds: 60, Changes: 10000},
+}
+
+// ParseSaveRules parses "<seconds> <changes> [<seconds> <changes> ...]". An
+// empty string disables automatic saves.
+func ParseSaveRules(s string) ([]SaveRule, error) {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save rules: %q", s)
+	}
+	rules := make([]SaveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil || seconds < 1 {
+			return nil, fmt.Errorf("invalid save rules: %q", s)
+		}
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes < 0 {
+			return nil, fmt.Errorf("invalid save rules: %q", s)
+		}
+		rules = append(rules, SaveRule{Seconds: seconds, Changes: changes})
+	}
+	return rules, nil
+}
+
+// BGSave writes a snapshot of the store in the background. The log is then
+// replaced by one based on the snapshot that only holds the writes applied
+// after it, so restarts load the snapshot and replay a short tail.
+func (l *Log) BGSave() error {
+	_, err := l.startSave()
+	return err
+}
+
+// Save is like BGSave but waits until the snapshot is on disk.
+func (l *Log) Save() error {
+	done, err := l.startSave()
+	if err != nil {
+		return err
+	}
+	return <-done
+}
+
+// SaveRules returns the rules that trigger a background save.
+func (l *Log) SaveRules() []SaveRule {
+	return *l.saveRules.Load()
+}
+
+// SetSaveRules replaces the rules that trigger a background save. No rules
+// disable automatic saves.
+func (l *Log) SetSaveRules(rules []SaveRule) {
+	l.saveRules.Store(&rules)
+}
+
+// LastSave returns the time of the last successful save.
+func (l *Log) LastSave() time.Time {
+	return time.Unix(l.lastSave.Load(), 0)
+}
+
+func (l *Log) startSave() (<-chan error, error) {
+	id, err := newSnapshotID()
+	if err != nil {
+		return nil, err
+	}
+	tmpSnapshot := l.snapshotFile + ".tmp"
+
+	return l.startRewrite(&rewriteJob{
+		busy:   ErrSaveInProgress,
+		failed: &l.lastSaveFailed,
+		prepare: func(tmpFile string, data map[string]store.Data) error {
+			snap := &snapshot.Snapshot{ID: id, CreatedAt: time.Now(), Data: data}
+			if err := snapshot.Write(tmpSnapshot, snap); err != nil {
+				return err
+			}
+			return os.WriteFile(tmpFile, []byte(header(id)), 0644)
+		},
+		// The log already refers to the new snapshot when it is renamed into
+		// place. If we crash in between, loadSnapshot finishes the rename.
+		commit: func(dirty int64) error {
+			if err := os.Rename(tmpSnapshot, l.snapshotFile); err != nil {
+				return err
+			}
+			l.dirty.Add(-dirty)
+			l.lastSave.Store(time.Now().Unix())
+			fmt.Printf("💾 Snapshot saved to %s\n", l.snapshotFile)
+			return nil
+		},
+	})
+}
+
+// loadSnapshot restores the snapshot the log is based on into the store.
+func (l *Log) loadSnapshot(id string) error {
+	if l.store == nil {
+		return fmt.Errorf("no store to load snapshot %s into", id)
+	}
+
+	path := l.snapshotFile
+	if current, _ := snapshot.ReadID(path); current != id {
+		// The log was swapped but the snapshot was not renamed yet.
+		tmpSnapshot := l.snapshotFile + ".tmp"
+		if pending, _ := snapshot.ReadID(tmpSnapshot); pending != id {
+			return fmt.Errorf("snapshot %s referenced by log file not found", id)
+		}
+		if err := os.Rename(tmpSnapshot, path); err != nil {
+			return fmt.Errorf("error restoring snapshot: %w", err)
+		}
+	}
+
+	snap, err := snapshot.Load(path)
+	if err != nil {
+		return fmt.Errorf("error loading snapshot: %w", err)
+	}
+	l.store.Restore(snap.Data)
+	fmt.Printf("💾 Loaded %d keys from snapshot %s\n", len(snap.Data), path)
+	return nil
+}
+
+// checkSaveRules runs on the writer goroutine once per second.
+func (l *Log) checkSaveRules() {
+	dirty := l.dirty.Load()
+	if dirty == 0 {
+		return
+	}
+	elapsed := time.Now().Unix() - l.lastSave.Load()
+	for _, rule := range l.SaveRules() {
+		if dirty < rule.Changes || elapsed < rule.Seconds {
+			continue
+		}
+		if l.saveScheduled.CompareAndSwap(false, true) {
+			go func() {
+				defer l.saveScheduled.Store(false)
+				if err := l.BGSave(); err == nil {
+					fmt.Printf("%d changes in %d seconds. Saving...\n", dirty, rule.Seconds)
+				}
+			}()
+		}
+		return
+	}
+}
+
+func newSnapshotID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
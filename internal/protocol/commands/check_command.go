@@ -1,16 +1,11 @@
 package commands
 
 import (
-	"fmt"
-
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
 	"github.com/teguhkurnia/redis-like/internal/store"
 )
 
-func handleExists(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) < 1 {
-		return []byte("-ERR wrong number of arguments for 'EXISTS' command\r\n")
-	}
-
+func handleExists(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
 	existsCount := 0
 	for _, arg := range cmd.Args {
 		key := string(arg)
@@ -19,7 +14,7 @@ func handleExists(cmd *Command, store *store.Store) []byte {
 		}
 	}
 
-	return fmt.Appendf(nil, ":%d\r\n", existsCount)
+	w.Int(int64(existsCount))
 }
 
 var ExistsSpec = &CommandSpec{
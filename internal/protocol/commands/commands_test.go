@@ -1,9 +1,13 @@
 package commands
 
 import (
+	"fmt"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
 	"github.com/teguhkurnia/redis-like/internal/store"
 )
 
@@ -13,11 +17,11 @@ func TestSetAndGet(t *testing.T) {
 	cmdGet := &Command{Name: "GET", Args: [][]byte{[]byte("key")}}
 
 	// Test SET
-	result := handleSet(cmdSet, s)
+	result := reply(handleSet, cmdSet, s)
 	assert.Equal(t, "+OK\r\n", string(result))
 
 	// Test GET
-	result = handleGet(cmdGet, s)
+	result = reply(handleGet, cmdGet, s)
 	assert.Equal(t, "$5\r\nvalue\r\n", string(result))
 }
 
@@ -27,12 +31,12 @@ func TestDel(t *testing.T) {
 	s.Set("key2", "value2")
 	cmd := &Command{Name: "DEL", Args: [][]byte{[]byte("key1"), []byte("key2"), []byte("key3")}} // key3 does not exist
 
-	result := handleDel(cmd, s)
+	result := reply(handleDel, cmd, s)
 	assert.Equal(t, ":2\r\n", string(result))
 
-	_, exists := s.Get("key1")
+	_, exists, _ := s.Get("key1")
 	assert.False(t, exists)
-	_, exists = s.Get("key2")
+	_, exists, _ = s.Get("key2")
 	assert.False(t, exists)
 }
 
@@ -43,12 +47,25 @@ func TestIncrDecr(t *testing.T) {
 	cmdDecr := &Command{Name: "DECR", Args: [][]byte{[]byte("mykey")}}
 
 	// Test INCR
-	result := handleIncr(cmdIncr, s)
+	result := reply(handleIncr, cmdIncr, s)
 	assert.Equal(t, ":11\r\n", string(result))
 
 	// Test DECR
-	result = handleDecr(cmdDecr, s)
+	result = reply(handleDecr, cmdDecr, s)
 	assert.Equal(t, ":10\r\n", string(result))
+
+	// Counters stay strings, so GET and the snapshot see the same value.
+	value, _, err := s.Get("mykey")
+	assert.NoError(t, err)
+	assert.Equal(t, "10", value)
+
+	s.Set("mykey", "ten")
+	result = reply(handleIncr, cmdIncr, s)
+	assert.Equal(t, "-ERR value is not an integer or out of range\r\n", string(result))
+
+	s.Set("mykey", "9223372036854775807")
+	result = reply(handleIncr, cmdIncr, s)
+	assert.Equal(t, "-ERR increment or decrement would overflow\r\n", string(result))
 }
 
 func TestPing(t *testing.T) {
@@ -57,11 +74,11 @@ func TestPing(t *testing.T) {
 	cmd2 := &Command{Name: "PING", Args: [][]byte{[]byte("hello")}}
 
 	// Test PING
-	result := handlePing(cmd1, s)
+	result := reply(handlePing, cmd1, s)
 	assert.Equal(t, "+PONG\r\n", string(result))
 
 	// Test PING with message
-	result = handlePing(cmd2, s)
+	result = reply(handlePing, cmd2, s)
 	assert.Equal(t, "$5\r\nhello\r\n", string(result))
 }
 
@@ -75,27 +92,27 @@ func TestListCommands(t *testing.T) {
 	cmdRPop := &Command{Name: "RPOP", Args: [][]byte{[]byte("mylist")}}
 
 	// Test LPUSH
-	result := handleLPush(cmdLPush, s)
+	result := reply(handleLPush, cmdLPush, s)
 	assert.Equal(t, ":2\r\n", string(result))
 
 	// Test RPUSH
-	result = handleRPush(cmdRPush, s)
+	result = reply(handleRPush, cmdRPush, s)
 	assert.Equal(t, ":3\r\n", string(result))
 
 	// Test LRANGE
-	result = handleLRange(cmdLRange, s)
+	result = reply(handleLRange, cmdLRange, s)
 	assert.Equal(t, "*3\r\n$5\r\nhello\r\n$5\r\nworld\r\n$1\r\n!\r\n", string(result))
 
 	// Test LLEN
-	result = handleLLen(cmdLLen, s)
+	result = reply(handleLLen, cmdLLen, s)
 	assert.Equal(t, ":3\r\n", string(result))
 
 	// Test LPOP
-	result = handleLPop(cmdLPop, s)
+	result = reply(handleLPop, cmdLPop, s)
 	assert.Equal(t, "*1\r\n$5\r\nhello\r\n", string(result))
 
 	// Test RPOP
-	result = handleRPop(cmdRPop, s)
+	result = reply(handleRPop, cmdRPop, s)
 	assert.Equal(t, "*1\r\n$1\r\n!\r\n", string(result))
 }
 
@@ -107,21 +124,21 @@ func TestHashCommands(t *testing.T) {
 	cmdHDel := &Command{Name: "HDEL", Args: [][]byte{[]byte("myhash"), []byte("field1"), []byte("field2")}}
 
 	// Test HSET
-	result := handleHSet(cmdHSet, s)
+	result := reply(handleHSet, cmdHSet, s)
 	assert.Equal(t, ":2\r\n", string(result))
 
 	// Test HGET
-	result = handleHGet(cmdHGet, s)
+	result = reply(handleHGet, cmdHGet, s)
 	assert.Equal(t, "$5\r\nHello\r\n", string(result))
 
 	// Test HGETALL
-	result = handleHGetAll(cmdHGetAll, s)
+	result = reply(handleHGetAll, cmdHGetAll, s)
 	assert.Contains(t, string(result), "*4\r\n")
 	assert.Contains(t, string(result), "$6\r\nfield1\r\n$5\r\nHello\r\n")
 	assert.Contains(t, string(result), "$6\r\nfield2\r\n$5\r\nWorld\r\n")
 
 	// Test HDEL
-	result = handleHDel(cmdHDel, s)
+	result = reply(handleHDel, cmdHDel, s)
 	assert.Equal(t, ":2\r\n", string(result))
 }
 
@@ -133,21 +150,21 @@ func TestSetCommands(t *testing.T) {
 	cmdSRem := &Command{Name: "SREM", Args: [][]byte{[]byte("myset"), []byte("member1"), []byte("member3")}}
 
 	// Test SADD
-	result := handleSAdd(cmdSAdd, s)
+	result := reply(handleSAdd, cmdSAdd, s)
 	assert.Equal(t, ":2\r\n", string(result))
 
 	// Test SMEMBERS
-	result = handleSMembers(cmdSMembers, s)
+	result = reply(handleSMembers, cmdSMembers, s)
 	assert.Contains(t, string(result), "*2\r\n")
 	assert.Contains(t, string(result), "$7\r\nmember1\r\n")
 	assert.Contains(t, string(result), "$7\r\nmember2\r\n")
 
 	// Test SISMEMBER
-	result = handleSIsMember(cmdSIsMember, s)
+	result = reply(handleSIsMember, cmdSIsMember, s)
 	assert.Equal(t, ":1\r\n", string(result))
 
 	// Test SREM
-	result = handleSRem(cmdSRem, s)
+	result = reply(handleSRem, cmdSRem, s)
 	assert.Equal(t, ":1\r\n", string(result))
 }
 
@@ -158,15 +175,15 @@ func TestSortedSetCommands(t *testing.T) {
 	cmdZRem := &Command{Name: "ZREM", Args: [][]byte{[]byte("myzset"), []byte("one"), []byte("three")}}
 
 	// Test ZADD
-	result := handleZAdd(cmdZAdd, s)
+	result := reply(handleZAdd, cmdZAdd, s)
 	assert.Equal(t, ":2\r\n", string(result))
 
 	// Test ZRANGE
-	result = handleZRange(cmdZRange, s)
+	result = reply(handleZRange, cmdZRange, s)
 	assert.Equal(t, "*2\r\n$3\r\none\r\n$3\r\ntwo\r\n", string(result))
 
 	// Test ZREM
-	result = handleZRem(cmdZRem, s)
+	result = reply(handleZRem, cmdZRem, s)
 	assert.Equal(t, ":1\r\n", string(result))
 }
 
@@ -177,10 +194,278 @@ func TestTimeCommands(t *testing.T) {
 	cmdTTL := &Command{Name: "TTL", Args: [][]byte{[]byte("key")}}
 
 	// Test EXPIRE
-	result := handleExpire(cmdExpire, s)
-	assert.Equal(t, "+OK\r\n", string(result))
+	result := reply(handleExpire, cmdExpire, s)
+	assert.Equal(t, ":1\r\n", string(result))
 
 	// Test TTL
-	result = handleTTL(cmdTTL, s)
+	result = reply(handleTTL, cmdTTL, s)
 	assert.Equal(t, ":10\r\n", string(result))
 }
+
+func TestExpirePropagatesAbsoluteTime(t *testing.T) {
+	s := store.NewStore()
+	s.Set("key", "value")
+	cmdExpire := &Command{Name: "EXPIRE", Args: [][]byte{[]byte("key"), []byte("10")}}
+
+	reply(handleExpire, cmdExpire, s)
+	propagated := ExpireSpec.Propagate(cmdExpire, s)
+	assert.Len(t, propagated, 1)
+	assert.Equal(t, "PEXPIREAT", propagated[0].Name)
+
+	at, _ := s.ExpireTime("key")
+	assert.Equal(t, strconv.FormatInt(at.UnixMilli(), 10), string(propagated[0].Args[1]))
+
+	// Replaying an expiration that already passed deletes the key.
+	expired := &Command{Name: "PEXPIREAT", Args: [][]byte{[]byte("key"), []byte("1000")}}
+	result := reply(handlePExpireAt, expired, s)
+	assert.Equal(t, ":1\r\n", string(result))
+	assert.False(t, s.Exists("key"))
+}
+
+func TestExpireFamily(t *testing.T) {
+	s := store.NewStore()
+	s.Set("key", "value")
+	run := func(handler handler, args ...string) string {
+		cmd := &Command{Name: "CMD"}
+		for _, arg := range args {
+			cmd.Args = append(cmd.Args, []byte(arg))
+		}
+		return string(reply(handler, cmd, s))
+	}
+
+	assert.Equal(t, ":-1\r\n", run(handlePTTL, "key"))
+	assert.Equal(t, ":-1\r\n", run(handleExpireTime, "key"))
+	assert.Equal(t, ":0\r\n", run(handlePExpire, "key", "1500", "XX"))
+	assert.Equal(t, ":0\r\n", run(handlePExpire, "key", "1500", "GT"))
+	assert.Equal(t, ":1\r\n", run(handlePExpire, "key", "1500", "NX"))
+	assert.Equal(t, ":0\r\n", run(handlePExpire, "key", "5000", "NX"))
+
+	pttl := s.PTTL("key")
+	assert.True(t, pttl > 1000 && pttl <= 1500, "pttl %d", pttl)
+	// TTL rounds PTTL to the nearest second, which is 2 until 1ms passed.
+	assert.Contains(t, []string{":1\r\n", ":2\r\n"}, run(handleTTL, "key"))
+
+	assert.Equal(t, ":0\r\n", run(handlePExpire, "key", "1000", "GT"))
+	assert.Equal(t, ":1\r\n", run(handlePExpire, "key", "1000", "LT"))
+	assert.Equal(t, ":1\r\n", run(handleExpire, "key", "100", "gt"))
+
+	at := time.Now().Add(time.Hour).Truncate(time.Second)
+	assert.Equal(t, ":1\r\n", run(handleExpireAt, "key", strconv.FormatInt(at.Unix(), 10)))
+	assert.Equal(t, fmt.Sprintf(":%d\r\n", at.Unix()), run(handleExpireTime, "key"))
+	assert.Equal(t, fmt.Sprintf(":%d\r\n", at.UnixMilli()), run(handlePExpireTime, "key"))
+
+	assert.Equal(t, ":1\r\n", run(handlePersist, "key"))
+	assert.Equal(t, ":0\r\n", run(handlePersist, "key"))
+	assert.Equal(t, ":-1\r\n", run(handleTTL, "key"))
+
+	assert.Equal(t, "-ERR NX and XX, GT or LT options at the same time are not compatible\r\n", run(handleExpire, "key", "10", "NX", "GT"))
+	assert.Equal(t, "-ERR GT and LT options at the same time are not compatible\r\n", run(handleExpire, "key", "10", "GT", "LT"))
+	assert.Equal(t, "-ERR value is not an integer or out of range\r\n", run(handleExpire, "key", "soon"))
+	assert.Contains(t, run(handleExpire, "key", "9223372036854775807"), "invalid expire time")
+
+	// Non-positive timeouts delete the key.
+	assert.Equal(t, ":1\r\n", run(handleExpire, "key", "-1"))
+	assert.False(t, s.Exists("key"))
+	assert.Equal(t, ":-2\r\n", run(handlePTTL, "key"))
+	assert.Equal(t, ":-2\r\n", run(handlePExpireTime, "key"))
+	assert.Equal(t, ":0\r\n", run(handleExpire, "key", "10"))
+}
+
+func TestSetOptions(t *testing.T) {
+	s := store.NewStore()
+	run := func(args ...string) string {
+		cmd := &Command{Name: "SET"}
+		for _, arg := range args {
+			cmd.Args = append(cmd.Args, []byte(arg))
+		}
+		return string(reply(handleSet, cmd, s))
+	}
+
+	assert.Equal(t, "+OK\r\n", run("lock", "a", "NX", "PX", "1500"))
+	assert.Equal(t, "$-1\r\n", run("lock", "b", "NX", "PX", "1500"))
+	pttl := s.PTTL("lock")
+	assert.True(t, pttl > 1000 && pttl <= 1500, "pttl %d", pttl)
+
+	assert.Equal(t, "$-1\r\n", run("missing", "a", "XX"))
+	assert.False(t, s.Exists("missing"))
+
+	// KEEPTTL retains the deadline, a plain SET clears it.
+	assert.Equal(t, "$1\r\na\r\n", run("lock", "c", "XX", "KEEPTTL", "GET"))
+	assert.Equal(t, pttl/1000, s.PTTL("lock")/1000)
+	assert.Equal(t, "$1\r\nc\r\n", run("lock", "d", "get"))
+	assert.Equal(t, int64(-1), s.PTTL("lock"))
+	assert.Equal(t, "$-1\r\n", run("fresh", "v", "GET"))
+
+	at := time.Now().Add(time.Hour).Truncate(time.Second)
+	assert.Equal(t, "+OK\r\n", run("key", "v", "EXAT", strconv.FormatInt(at.Unix(), 10)))
+	expireAt, _ := s.ExpireTime("key")
+	assert.Equal(t, at.UnixMilli(), expireAt.UnixMilli())
+
+	s.RPush("list", []string{"item"})
+	assert.Contains(t, run("list", "v", "GET"), "WRONGTYPE")
+	assert.Equal(t, "$-1\r\n", run("list", "v", "NX"))
+
+	assert.Equal(t, "-ERR syntax error\r\n", run("key", "v", "NX", "XX"))
+	assert.Equal(t, "-ERR syntax error\r\n", run("key", "v", "EX", "10", "KEEPTTL"))
+	assert.Equal(t, "-ERR syntax error\r\n", run("key", "v", "PX"))
+	assert.Equal(t, "-ERR value is not an integer or out of range\r\n", run("key", "v", "EX", "ten"))
+	assert.Equal(t, "-ERR invalid expire time in 'set' command\r\n", run("key", "v", "EX", "0"))
+}
+
+func TestSetPropagatesAbsoluteTime(t *testing.T) {
+	s := store.NewStore()
+	cmd := &Command{Name: "SET", Args: [][]byte{[]byte("key"), []byte("v"), []byte("NX"), []byte("EX"), []byte("10"), []byte("GET")}}
+
+	reply(handleSet, cmd, s)
+	propagated := SetSpec.Propagate(cmd, s)
+	assert.Len(t, propagated, 1)
+	at, _ := s.ExpireTime("key")
+	assert.Equal(t, []string{"key", "v", "NX", "PXAT", strconv.FormatInt(at.UnixMilli(), 10)}, argStrings(propagated[0]))
+
+	// A deadline already in the past leaves nothing behind.
+	cmd = &Command{Name: "SET", Args: [][]byte{[]byte("key"), []byte("v"), []byte("PXAT"), []byte("1000")}}
+	assert.Equal(t, "+OK\r\n", string(reply(handleSet, cmd, s)))
+	assert.False(t, s.Exists("key"))
+	propagated = SetSpec.Propagate(cmd, s)
+	assert.Equal(t, "DEL", propagated[0].Name)
+
+	// A SET whose condition failed is not logged at all.
+	s.Set("key", "old")
+	for _, condition := range []string{"NX", "XX"} {
+		key := map[string]string{"NX": "key", "XX": "missing"}[condition]
+		cmd = &Command{Name: "SET", Args: [][]byte{[]byte(key), []byte("new"), []byte(condition)}}
+		assert.Equal(t, "$-1\r\n", string(reply(handleSet, cmd, s)), condition)
+		assert.Empty(t, SetSpec.Propagate(cmd, s), condition)
+	}
+}
+
+func TestCollectionWritesKeepTTL(t *testing.T) {
+	s := store.NewStore()
+	s.RPush("list", []string{"a"})
+	s.SAdd("set", []string{"a"})
+	at := time.Now().Add(time.Hour)
+	s.ExpireAt("list", at, store.ExpireAlways)
+	s.ExpireAt("set", at, store.ExpireAlways)
+
+	s.LPush("list", []string{"b"})
+	s.RPush("list", []string{"c"})
+	s.SAdd("set", []string{"b"})
+	for _, key := range []string{"list", "set"} {
+		expireAt, _ := s.ExpireTime(key)
+		assert.Equal(t, at.UnixMilli(), expireAt.UnixMilli(), key)
+	}
+}
+
+type handler = func(Client, *Command, *store.Store, *resp.Writer)
+
+// fakeClient is the connection handlers see in tests. Like the server's
+// client, it switches its writer when the protocol changes.
+type fakeClient struct {
+	name string
+	w    *resp.Writer
+}
+
+func (c *fakeClient) ID() int64 {
+	return 7
+}
+
+func (c *fakeClient) Addr() string {
+	return "127.0.0.1:7000"
+}
+
+func (c *fakeClient) Name() string {
+	return c.name
+}
+
+func (c *fakeClient) SetName(name string) {
+	c.name = name
+}
+
+func (c *fakeClient) Protocol() int {
+	return c.w.Protocol()
+}
+
+func (c *fakeClient) SetProtocol(proto int) {
+	c.w.SetProtocol(proto)
+}
+
+// reply runs handler and returns the reply it wrote, in RESP2.
+func reply(handler handler, cmd *Command, s *store.Store) string {
+	return replyIn(resp.RESP2, handler, cmd, s)
+}
+
+func replyIn(proto int, handler handler, cmd *Command, s *store.Store) string {
+	w := resp.NewWriter(proto)
+	defer w.Release()
+	handler(&fakeClient{w: w}, cmd, s, w)
+	return string(w.Bytes())
+}
+
+func argStrings(cmd *Command) []string {
+	args := make([]string, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		args[i] = string(arg)
+	}
+	return args
+}
+
+func TestHello(t *testing.T) {
+	s := store.NewStore()
+	w := resp.NewWriter(resp.RESP2)
+	client := &fakeClient{w: w}
+	cmd := &Command{Name: "HELLO", Args: [][]byte{[]byte("3"), []byte("AUTH"), []byte("default"), []byte("secret"), []byte("SETNAME"), []byte("worker")}}
+	handleHello(client, cmd, s, w)
+	assert.Equal(t, resp.RESP3, w.Protocol())
+	assert.Equal(t, "worker", client.Name())
+	assert.Contains(t, string(w.Bytes()), "%7\r\n")
+	assert.Contains(t, string(w.Bytes()), "$5\r\nproto\r\n:3\r\n")
+	assert.Contains(t, string(w.Bytes()), "$2\r\nid\r\n:7\r\n")
+
+	w.Reset()
+	handleHello(client, &Command{Name: "HELLO", Args: [][]byte{[]byte("2")}}, s, w)
+	assert.Equal(t, resp.RESP2, w.Protocol())
+	assert.Equal(t, "worker", client.Name(), "HELLO without SETNAME keeps the name")
+	assert.Contains(t, string(w.Bytes()), "*14\r\n")
+
+	errors := map[string][][]byte{
+		"-NOPROTO unsupported protocol version\r\n":                                    {[]byte("4")},
+		"-ERR Protocol version is not an integer or out of range\r\n":                  {[]byte("three")},
+		"-WRONGPASS invalid username-password pair or user is disabled.\r\n":           {[]byte("3"), []byte("AUTH"), []byte("admin"), []byte("secret")},
+		"-ERR Syntax error in HELLO option 'AUTH'\r\n":                                 {[]byte("3"), []byte("AUTH"), []byte("default")},
+		"-ERR Client names cannot contain spaces, newlines or special characters.\r\n": {[]byte("3"), []byte("SETNAME"), []byte("my worker")},
+	}
+	for want, args := range errors {
+		w.Reset()
+		handleHello(client, &Command{Name: "HELLO", Args: args}, s, w)
+		assert.Equal(t, want, string(w.Bytes()))
+		assert.Equal(t, resp.RESP2, w.Protocol(), "a failed HELLO keeps the protocol")
+	}
+}
+
+func TestRESP3Replies(t *testing.T) {
+	s := store.NewStore()
+	s.HSet("hash", "field", "value")
+	s.SAdd("set", []string{"member"})
+	s.ZAdd("zset", []store.SortedSet{{Score: 1.5, Member: "one"}})
+
+	cmd := &Command{Name: "HGETALL", Args: [][]byte{[]byte("hash")}}
+	assert.Equal(t, "%1\r\n$5\r\nfield\r\n$5\r\nvalue\r\n", replyIn(resp.RESP3, handleHGetAll, cmd, s))
+
+	cmd = &Command{Name: "SMEMBERS", Args: [][]byte{[]byte("set")}}
+	assert.Equal(t, "~1\r\n$6\r\nmember\r\n", replyIn(resp.RESP3, handleSMembers, cmd, s))
+
+	cmd = &Command{Name: "ZRANGE", Args: [][]byte{[]byte("zset"), []byte("0"), []byte("-1"), []byte("WITHSCORES")}}
+	assert.Equal(t, "*1\r\n*2\r\n$3\r\none\r\n,1.5\r\n", replyIn(resp.RESP3, handleZRange, cmd, s))
+	assert.Equal(t, "*2\r\n$3\r\none\r\n$3\r\n1.5\r\n", reply(handleZRange, cmd, s))
+
+	cmd = &Command{Name: "GET", Args: [][]byte{[]byte("missing")}}
+	assert.Equal(t, "_\r\n", replyIn(resp.RESP3, handleGet, cmd, s))
+}
+
+// TestReplyFormatting covers replies that used to be malformed.
+func TestReplyFormatting(t *testing.T) {
+	s := store.NewStore()
+	cmd := &Command{Name: "HGETALL", Args: [][]byte{[]byte("missing")}}
+	assert.Equal(t, "*0\r\n", reply(handleHGetAll, cmd, s))
+	assert.Equal(t, "%0\r\n", replyIn(resp.RESP3, handleHGetAll, cmd, s))
+}
@@ -1,16 +1,23 @@
 package commands
 
 import (
-	"fmt"
+	"strconv"
+	"strings"
 
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
 	"github.com/teguhkurnia/redis-like/internal/store"
 )
 
-func handlePing(cmd *Command, store *store.Store) []byte {
+// RedisVersion is the Redis release whose behaviour the server follows. It is
+// reported to clients, which use it to decide which features to rely on.
+const RedisVersion = "7.2.0"
+
+func handlePing(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
 	if len(cmd.Args) == 1 {
-		return fmt.Appendf(nil, "$%d\r\n%s\r\n", len(cmd.Args[0]), cmd.Args[0])
+		w.Bulk(cmd.Args[0])
+		return
 	}
-	return []byte("+PONG\r\n")
+	w.Status("PONG")
 }
 
 var PingSpec = &CommandSpec{
@@ -24,3 +31,92 @@ var PingSpec = &CommandSpec{
 		"summary": "Returns PONG, or the argument if provided.",
 	},
 }
+
+// ErrInvalidClientName is the error for client names ValidClientName refuses.
+const ErrInvalidClientName = "ERR Client names cannot contain spaces, newlines or special characters."
+
+// ValidClientName reports whether name can be set with HELLO or CLIENT
+// SETNAME. Names are shown in CLIENT LIST, so they are limited to printable
+// characters other than space.
+func ValidClientName(name []byte) bool {
+	for _, c := range name {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// handleHello switches the client to the requested protocol version, names
+// it and describes the server. There are no ACLs, so AUTH only accepts the
+// default user, which has no password, like Redis without requirepass.
+func handleHello(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
+	proto := client.Protocol()
+	if len(cmd.Args) > 0 {
+		version, err := strconv.Atoi(string(cmd.Args[0]))
+		if err != nil {
+			w.Error("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if version < resp.RESP2 || version > resp.RESP3 {
+			w.Error("NOPROTO unsupported protocol version")
+			return
+		}
+		proto = version
+	}
+
+	name, setName := "", false
+	for i := 1; i < len(cmd.Args); i++ {
+		option := strings.ToUpper(string(cmd.Args[i]))
+		switch {
+		case option == "AUTH" && i+2 < len(cmd.Args):
+			if string(cmd.Args[i+1]) != "default" {
+				w.Error("WRONGPASS invalid username-password pair or user is disabled.")
+				return
+			}
+			i += 2
+		case option == "SETNAME" && i+1 < len(cmd.Args):
+			if !ValidClientName(cmd.Args[i+1]) {
+				w.Error(ErrInvalidClientName)
+				return
+			}
+			name, setName = string(cmd.Args[i+1]), true
+			i++
+		default:
+			w.Errorf("ERR Syntax error in HELLO option '%s'", cmd.Args[i])
+			return
+		}
+	}
+
+	if setName {
+		client.SetName(name)
+	}
+	client.SetProtocol(proto)
+	w.Map(7)
+	w.BulkString("server")
+	w.BulkString("redis")
+	w.BulkString("version")
+	w.BulkString(RedisVersion)
+	w.BulkString("proto")
+	w.Int(int64(proto))
+	w.BulkString("id")
+	w.Int(client.ID())
+	w.BulkString("mode")
+	w.BulkString("standalone")
+	w.BulkString("role")
+	w.BulkString("master")
+	w.BulkString("modules")
+	w.Array(0)
+}
+
+var HelloSpec = &CommandSpec{
+	Handler:  handleHello,
+	Arity:    -1,
+	Flags:    []string{"noscript", "loading", "stale", "fast", "no-auth"},
+	FirstKey: 0,
+	LastKey:  0,
+	KeyStep:  0,
+	Documentation: map[string]any{
+		"summary": "Handshakes with the server, optionally switching to another protocol version.",
+	},
+}
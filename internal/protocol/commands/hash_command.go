@@ -1,14 +1,14 @@
 package commands
 
 import (
-	"fmt"
-
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
 	"github.com/teguhkurnia/redis-like/internal/store"
 )
 
-func handleHSet(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) < 3 || (len(cmd.Args)-1)%2 != 0 {
-		return []byte("-ERR wrong number of arguments for 'HSET' command\r\n")
+func handleHSet(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
+	if (len(cmd.Args)-1)%2 != 0 {
+		WrongArity(w, cmd)
+		return
 	}
 
 	key := string(cmd.Args[0])
@@ -17,15 +17,20 @@ func handleHSet(cmd *Command, store *store.Store) []byte {
 	for i := 1; i < len(cmd.Args); i += 2 {
 		field := string(cmd.Args[i])
 		value := string(cmd.Args[i+1])
-		count += store.HSet(key, field, value)
+		added, err := store.HSet(key, field, value)
+		if err != nil {
+			w.Error(err.Error())
+			return
+		}
+		count += added
 	}
 
-	return fmt.Appendf(nil, ":%d\r\n", count)
+	w.Int(int64(count))
 }
 
 var HSetSpec = &CommandSpec{
 	Handler:  handleHSet,
-	Arity:    -3, // Arity is -3 because it expects at least one key and pairs of field-value
+	Arity:    -4, // Arity is -4 because it expects a key and at least one field-value pair
 	Flags:    []string{"write", "deny-oom"},
 	FirstKey: 1,
 	LastKey:  1,
@@ -35,19 +40,19 @@ var HSetSpec = &CommandSpec{
 	},
 }
 
-func handleHGet(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) != 2 {
-		return []byte("-ERR wrong number of arguments for 'HGET' command\r\n")
-	}
-
+func handleHGet(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
 	key := string(cmd.Args[0])
 	field := string(cmd.Args[1])
-	value, exists := store.HGet(key, field)
+	value, exists, err := store.HGet(key, field)
+	if err != nil {
+		w.Error(err.Error())
+		return
+	}
 	if !exists {
-		return []byte("$-1\r\n")
+		w.Null()
+		return
 	}
-
-	return fmt.Appendf(nil, "$%d\r\n%s\r\n", len(value), value)
+	w.BulkString(value)
 }
 
 var HGetSpec = &CommandSpec{
@@ -62,22 +67,19 @@ var HGetSpec = &CommandSpec{
 	},
 }
 
-func handleHGetAll(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) != 1 {
-		return []byte("-ERR wrong number of arguments for 'HGETALL' command\r\n")
-	}
-
+func handleHGetAll(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
 	key := string(cmd.Args[0])
-	hash, exists := store.HGetAll(key)
-	if !exists {
-		return []byte("*\r\n")
+	hash, err := store.HGetAll(key)
+	if err != nil {
+		w.Error(err.Error())
+		return
 	}
 
-	result := fmt.Sprintf("*%d\r\n", len(hash)*2)
+	w.Map(len(hash))
 	for field, value := range hash {
-		result += fmt.Sprintf("$%d\r\n%s\r\n$%d\r\n%s\r\n", len(field), field, len(value), value)
+		w.BulkString(field)
+		w.BulkString(value)
 	}
-	return []byte(result)
 }
 
 var HGetAllSpec = &CommandSpec{
@@ -92,25 +94,26 @@ var HGetAllSpec = &CommandSpec{
 	},
 }
 
-func handleHDel(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) < 1 {
-		return []byte("-ERR wrong number of arguments for 'HDEL' command\r\n")
-	}
-
+func handleHDel(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
 	deleted := 0
 	key := string(cmd.Args[0])
 	fields := cmd.Args[1:]
 	for _, field := range fields {
-		deleted += store.HDel(key, string(field))
+		n, err := store.HDel(key, string(field))
+		if err != nil {
+			w.Error(err.Error())
+			return
+		}
+		deleted += n
 	}
 
-	return fmt.Appendf(nil, ":%d\r\n", deleted)
+	w.Int(int64(deleted))
 }
 
 var HDelSpec = &CommandSpec{
 	Handler:  handleHDel,
-	Arity:    -2, // Arity is -2 because it expects at least one key and one field
-	Flags:    []string{"write", "deny-oom"},
+	Arity:    -3, // Arity is -3 because it expects a key and at least one field
+	Flags:    []string{"write", "fast"},
 	FirstKey: 1,
 	LastKey:  1,
 	KeyStep:  1,
@@ -4,24 +4,61 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
 	"github.com/teguhkurnia/redis-like/internal/store"
 )
 
 type Command struct {
 	Name string
 	Args [][]byte
+
+	// skipped is set by the handler of a conditional write, such as SET
+	// NX, whose condition failed, so that nothing is propagated for it.
+	skipped bool
+}
+
+// Client is the connection that sent a command. The server implements it;
+// it is an interface so commands do not depend on the server.
+type Client interface {
+	ID() int64
+	// Addr is the address the client connected from.
+	Addr() string
+	Name() string
+	SetName(name string)
+	Protocol() int
+	// SetProtocol switches the RESP version of the client's replies,
+	// starting with the reply being written.
+	SetProtocol(proto int)
 }
 
 type CommandSpec struct {
-	Handler       func(cmd *Command, store *store.Store) []byte
+	// Handler runs the command sent by client and writes its reply to w,
+	// in the client's protocol version. Commands replayed from the AOF have
+	// no client.
+	Handler func(client Client, cmd *Command, store *store.Store, w *resp.Writer)
+	// Propagate returns the commands written to the AOF in place of a
+	// successful cmd, e.g. to turn relative times into absolute ones. When
+	// nil, cmd itself is written.
+	Propagate     func(cmd *Command, store *store.Store) []*Command
 	Documentation map[string]any
-	Arity         int
-	Flags         []string
-	FirstKey      int
-	LastKey       int
-	KeyStep       int
+	// Arity is the number of arguments including the command name, or -N
+	// for at least N. HandleCommand rejects commands that do not match.
+	Arity    int
+	Flags    []string
+	FirstKey int
+	LastKey  int
+	KeyStep  int
 }
 
+// WrongArity writes the error Redis replies with when cmd has the wrong
+// number of arguments.
+func WrongArity(w *resp.Writer, cmd *Command) {
+	w.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd.Name))
+}
+
+// FromLog parses a line written by the legacy, space-separated AOF format.
+// It is only used to migrate old log files; values containing whitespace
+// cannot be recovered faithfully from this format.
 func FromLog(line string) (*Command, error) {
 	parts := strings.SplitN(line, " ", 2)
 	if len(parts) < 2 {
@@ -43,20 +80,21 @@ func parseArgs(argsStr string) [][]byte {
 	return byteArgs
 }
 
-func (cmd *Command) ToLog() (string, error) {
+// ToLog encodes the command as a RESP array of bulk strings, which is
+// binary-safe and can be replayed with the regular RESP parser.
+func (cmd *Command) ToLog() ([]byte, error) {
 	// A command must have a name.
 	if strings.TrimSpace(cmd.Name) == "" {
-		return "", fmt.Errorf("command name cannot be empty")
+		return nil, fmt.Errorf("command name cannot be empty")
 	}
 
-	// Start with the command name.
-	parts := []string{cmd.Name}
-
-	// Append each argument, converting it from []byte to string.
+	buf := fmt.Appendf(nil, "*%d\r\n", len(cmd.Args)+1)
+	buf = fmt.Appendf(buf, "$%d\r\n%s\r\n", len(cmd.Name), cmd.Name)
 	for _, arg := range cmd.Args {
-		parts = append(parts, string(arg))
+		buf = fmt.Appendf(buf, "$%d\r\n", len(arg))
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
 	}
 
-	// Join all parts with a space to create the final log line.
-	return strings.Join(parts, " "), nil
+	return buf, nil
 }
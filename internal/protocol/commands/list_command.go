@@ -1,17 +1,13 @@
 package commands
 
 import (
-	"fmt"
 	"strconv"
 
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
 	"github.com/teguhkurnia/redis-like/internal/store"
 )
 
-func handleLPush(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) < 2 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", cmd.Name)
-	}
-
+func handleLPush(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
 	key := string(cmd.Args[0])
 	values := cmd.Args[1:]
 	strValues := make([]string, len(values))
@@ -19,15 +15,19 @@ func handleLPush(cmd *Command, store *store.Store) []byte {
 		strValues[i] = string(v)
 	}
 
-	count := store.LPush(key, strValues)
+	count, err := store.LPush(key, strValues)
+	if err != nil {
+		w.Error(err.Error())
+		return
+	}
 
-	return fmt.Appendf(nil, ":%d\r\n", count)
+	w.Int(int64(count))
 }
 
 var LPushSpec = &CommandSpec{
 	Handler:  handleLPush,
 	Arity:    -3, // -3 means at least 2 arguments
-	Flags:    []string{"write"},
+	Flags:    []string{"write", "deny-oom"},
 	FirstKey: 1,
 	LastKey:  1,
 	KeyStep:  1,
@@ -36,25 +36,26 @@ var LPushSpec = &CommandSpec{
 	},
 }
 
-func handleRPush(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) < 2 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", cmd.Name)
-	}
+func handleRPush(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
 	key := string(cmd.Args[0])
 	values := cmd.Args[1:]
 	strValues := make([]string, len(values))
 	for i, v := range values {
 		strValues[i] = string(v)
 	}
-	count := store.RPush(key, strValues)
+	count, err := store.RPush(key, strValues)
+	if err != nil {
+		w.Error(err.Error())
+		return
+	}
 
-	return fmt.Appendf(nil, ":%d\r\n", count)
+	w.Int(int64(count))
 }
 
 var RPushSpec = &CommandSpec{
 	Handler:  handleRPush,
 	Arity:    -3, // -3 means at least 2 arguments
-	Flags:    []string{"write"},
+	Flags:    []string{"write", "deny-oom"},
 	FirstKey: 1,
 	LastKey:  1,
 	KeyStep:  1,
@@ -63,38 +64,39 @@ var RPushSpec = &CommandSpec{
 	},
 }
 
-func handleLRange(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) < 3 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", cmd.Name)
-	}
-
+func handleLRange(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
 	key := string(cmd.Args[0])
 	start, err := strconv.Atoi(string(cmd.Args[1]))
 	if err != nil {
-		return fmt.Appendf(nil, "-ERR invalid start for '%s' command\r\n", cmd.Name)
+		w.Errorf("ERR invalid start for '%s' command", cmd.Name)
+		return
 	}
 	end, err := strconv.Atoi(string(cmd.Args[2]))
 	if err != nil {
-		return fmt.Appendf(nil, "-ERR invalid end for '%s' command\r\n", cmd.Name)
+		w.Errorf("ERR invalid end for '%s' command", cmd.Name)
+		return
 	}
 
-	values := store.LRange(key, start, end)
+	values, err := store.LRange(key, start, end)
+	if err != nil {
+		w.Error(err.Error())
+		return
+	}
 
 	if len(values) == 0 {
-		return []byte("$-1\r\n")
+		w.Null()
+		return
 	}
 
-	response := fmt.Sprintf("*%d\r\n", len(values))
+	w.Array(len(values))
 	for _, value := range values {
-		response += fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+		w.BulkString(value)
 	}
-
-	return []byte(response)
 }
 
 var LRangeSpec = &CommandSpec{
 	Handler:  handleLRange,
-	Arity:    3, // Exactly 3 arguments: key, start, end
+	Arity:    4, // LRANGE plus exactly 3 arguments: key, start, end
 	Flags:    []string{"readonly"},
 	FirstKey: 1,
 	LastKey:  1,
@@ -104,9 +106,10 @@ var LRangeSpec = &CommandSpec{
 	},
 }
 
-func handleLPop(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) != 1 && len(cmd.Args) != 2 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", cmd.Name)
+func handleLPop(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
+	if len(cmd.Args) > 2 {
+		WrongArity(w, cmd)
+		return
 	}
 
 	key := string(cmd.Args[0])
@@ -115,22 +118,30 @@ func handleLPop(cmd *Command, store *store.Store) []byte {
 		var err error
 		count, err = strconv.Atoi(string(cmd.Args[1]))
 		if err != nil {
-			return fmt.Appendf(nil, "-ERR invalid count for '%s' command\r\n", cmd.Name)
+			w.Errorf("ERR invalid count for '%s' command", cmd.Name)
+			return
+		}
+		if count < 0 {
+			w.Error("ERR value is out of range, must be positive")
+			return
 		}
 	}
 
-	values := store.LPop(key, count)
+	values, err := store.LPop(key, count)
+	if err != nil {
+		w.Error(err.Error())
+		return
+	}
 
 	if len(values) == 0 {
-		return []byte("$-1\r\n")
+		w.Null()
+		return
 	}
 
-	response := fmt.Sprintf("*%d\r\n", len(values))
+	w.Array(len(values))
 	for _, value := range values {
-		response += fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+		w.BulkString(value)
 	}
-
-	return []byte(response)
 }
 
 var LPopSpec = &CommandSpec{
@@ -145,9 +156,10 @@ var LPopSpec = &CommandSpec{
 	},
 }
 
-func handleRPop(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) != 1 || len(cmd.Args) > 2 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", cmd.Name)
+func handleRPop(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
+	if len(cmd.Args) > 2 {
+		WrongArity(w, cmd)
+		return
 	}
 
 	key := string(cmd.Args[0])
@@ -156,22 +168,30 @@ func handleRPop(cmd *Command, store *store.Store) []byte {
 		var err error
 		count, err = strconv.Atoi(string(cmd.Args[1]))
 		if err != nil {
-			return fmt.Appendf(nil, "-ERR invalid count for '%s' command\r\n", cmd.Name)
+			w.Errorf("ERR invalid count for '%s' command", cmd.Name)
+			return
+		}
+		if count < 0 {
+			w.Error("ERR value is out of range, must be positive")
+			return
 		}
 	}
 
-	values := store.RPop(key, count)
+	values, err := store.RPop(key, count)
+	if err != nil {
+		w.Error(err.Error())
+		return
+	}
 
 	if len(values) == 0 {
-		return []byte("$-1\r\n")
+		w.Null()
+		return
 	}
 
-	response := fmt.Sprintf("*%d\r\n", len(values))
+	w.Array(len(values))
 	for _, value := range values {
-		response += fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+		w.BulkString(value)
 	}
-
-	return []byte(response)
 }
 
 var RPopSpec = &CommandSpec{
@@ -186,18 +206,15 @@ var RPopSpec = &CommandSpec{
 	},
 }
 
-func handleLLen(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) != 1 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", cmd.Name)
-	}
-
+func handleLLen(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
 	key := string(cmd.Args[0])
-	length, ok := store.LLen(key)
-	if !ok {
-		return fmt.Appendf(nil, "-ERR WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	length, err := store.LLen(key)
+	if err != nil {
+		w.Error(err.Error())
+		return
 	}
 
-	return fmt.Appendf(nil, ":%d\r\n", length)
+	w.Int(int64(length))
 }
 
 var LLenSpec = &CommandSpec{
@@ -3,14 +3,11 @@ package commands
 import (
 	"fmt"
 
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
 	"github.com/teguhkurnia/redis-like/internal/store"
 )
 
-func handleSAdd(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) < 2 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command", cmd.Name)
-	}
-
+func handleSAdd(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
 	key := cmd.Args[0]
 	members := cmd.Args[1:]
 	membersStr := make([]string, len(members))
@@ -18,15 +15,19 @@ func handleSAdd(cmd *Command, store *store.Store) []byte {
 		membersStr[i] = string(member)
 	}
 
-	count := store.SAdd(string(key), membersStr)
+	count, err := store.SAdd(string(key), membersStr)
+	if err != nil {
+		w.Error(err.Error())
+		return
+	}
 
-	return fmt.Appendf(nil, ":%d\r\n", count)
+	w.Int(int64(count))
 }
 
 var SAddSpec = &CommandSpec{
 	Handler:  handleSAdd,
 	Arity:    -3,
-	Flags:    []string{"write"},
+	Flags:    []string{"write", "deny-oom"},
 	FirstKey: 1,
 	LastKey:  1,
 	KeyStep:  1,
@@ -35,11 +36,7 @@ var SAddSpec = &CommandSpec{
 	},
 }
 
-func handleSRem(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) < 2 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command", cmd.Name)
-	}
-
+func handleSRem(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
 	key := cmd.Args[0]
 	members := cmd.Args[1:]
 	membersStr := make([]string, len(members))
@@ -47,9 +44,13 @@ func handleSRem(cmd *Command, store *store.Store) []byte {
 		membersStr[i] = string(member)
 	}
 
-	count := store.SRem(string(key), membersStr)
+	count, err := store.SRem(string(key), membersStr)
+	if err != nil {
+		w.Error(err.Error())
+		return
+	}
 
-	return fmt.Appendf(nil, ":%d\r\n", count)
+	w.Int(int64(count))
 }
 
 var SRemSpec = &CommandSpec{
@@ -64,21 +65,19 @@ var SRemSpec = &CommandSpec{
 	},
 }
 
-func handleSMembers(cmd *Command, store *store.Store) []byte {
+func handleSMembers(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
 	fmt.Printf("Handling SMembers command with args: %v\n", cmd.Args)
-	if len(cmd.Args) != 1 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", cmd.Name)
-	}
-
 	key := cmd.Args[0]
-	members := store.SMembers(string(key))
+	members, err := store.SMembers(string(key))
+	if err != nil {
+		w.Error(err.Error())
+		return
+	}
 
-	result := fmt.Sprintf("*%d\r\n", len(members))
+	w.Set(len(members))
 	for _, member := range members {
-		result += fmt.Sprintf("$%d\r\n%s\r\n", len(member), member)
+		w.BulkString(member)
 	}
-
-	return []byte(result)
 }
 
 var SMembersSpec = &CommandSpec{
@@ -93,20 +92,21 @@ var SMembersSpec = &CommandSpec{
 	},
 }
 
-func handleSIsMember(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) != 2 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", cmd.Name)
-	}
-
+func handleSIsMember(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
 	key := cmd.Args[0]
 	member := cmd.Args[1]
 
-	isMember := store.SIsMember(string(key), string(member))
+	isMember, err := store.SIsMember(string(key), string(member))
+	if err != nil {
+		w.Error(err.Error())
+		return
+	}
 
 	if isMember {
-		return []byte(":1\r\n")
+		w.Int(1)
+		return
 	}
-	return []byte(":0\r\n")
+	w.Int(0)
 }
 
 var SIsMemberSpec = &CommandSpec{
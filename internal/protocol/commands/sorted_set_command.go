@@ -1,16 +1,17 @@
 package commands
 
 import (
-	"fmt"
-
 	"strconv"
+	"strings"
 
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
 	"github.com/teguhkurnia/redis-like/internal/store"
 )
 
-func handleZAdd(cmd *Command, s *store.Store) []byte {
-	if len(cmd.Args) < 3 || (len(cmd.Args)-1)%2 != 0 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", cmd.Name)
+func handleZAdd(client Client, cmd *Command, s *store.Store, w *resp.Writer) {
+	if (len(cmd.Args)-1)%2 != 0 {
+		WrongArity(w, cmd)
+		return
 	}
 
 	key := cmd.Args[0]
@@ -19,7 +20,8 @@ func handleZAdd(cmd *Command, s *store.Store) []byte {
 	for i := 0; i < len(members); i += 2 {
 		score, err := strconv.ParseFloat(string(members[i]), 64)
 		if err != nil {
-			return fmt.Appendf(nil, "-ERR score is not a valid number: %s\r\n", members[i])
+			w.Errorf("ERR score is not a valid number: %s", members[i])
+			return
 		}
 		member := string(members[i+1])
 		membersStruct = append(membersStruct, store.SortedSet{
@@ -28,15 +30,19 @@ func handleZAdd(cmd *Command, s *store.Store) []byte {
 		})
 	}
 
-	count := s.ZAdd(string(key), membersStruct)
+	count, err := s.ZAdd(string(key), membersStruct)
+	if err != nil {
+		w.Error(err.Error())
+		return
+	}
 
-	return fmt.Appendf(nil, ":%d\r\n", count)
+	w.Int(int64(count))
 }
 
 var ZAddSpec = &CommandSpec{
 	Handler:  handleZAdd,
-	Arity:    -3,
-	Flags:    []string{"write"},
+	Arity:    -4, // key and score member pairs
+	Flags:    []string{"write", "deny-oom"},
 	FirstKey: 1,
 	LastKey:  1,
 	KeyStep:  1,
@@ -45,48 +51,56 @@ var ZAddSpec = &CommandSpec{
 	},
 }
 
-func handleZRange(cmd *Command, s *store.Store) []byte {
-	if len(cmd.Args) < 3 || len(cmd.Args) > 4 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", cmd.Name)
-	}
-
+func handleZRange(client Client, cmd *Command, s *store.Store, w *resp.Writer) {
 	key := cmd.Args[0]
 	start, err := strconv.Atoi(string(cmd.Args[1]))
 	if err != nil {
-		return fmt.Appendf(nil, "-ERR start index is not a valid integer: %s\r\n", cmd.Args[1])
+		w.Errorf("ERR start index is not a valid integer: %s", cmd.Args[1])
+		return
 	}
-	end := -1
-	if len(cmd.Args) > 2 {
-		end, err = strconv.Atoi(string(cmd.Args[2]))
-		if err != nil {
-			return fmt.Appendf(nil, "-ERR end index is not a valid integer: %s\r\n", cmd.Args[2])
-		}
+	end, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil {
+		w.Errorf("ERR end index is not a valid integer: %s", cmd.Args[2])
+		return
 	}
 
 	withScores := false
-	if len(cmd.Args) == 4 && string(cmd.Args[3]) == "WITHSCORES" {
+	for _, option := range cmd.Args[3:] {
+		if !strings.EqualFold(string(option), "WITHSCORES") {
+			w.Error("ERR syntax error")
+			return
+		}
 		withScores = true
 	}
 
-	members := s.ZRange(string(key), start, end)
-	results := ""
-	if withScores {
-		results += fmt.Sprintf("*%d\r\n", len(members)*2)
-	} else {
-		results += fmt.Sprintf("*%d\r\n", len(members))
+	members, err := s.ZRange(string(key), start, end)
+	if err != nil {
+		w.Error(err.Error())
+		return
+	}
+	if !withScores {
+		w.Array(len(members))
+		for _, member := range members {
+			w.BulkString(member.Member)
+		}
+		return
 	}
 
+	// RESP3 clients get each member paired with its score as a double,
+	// RESP2 clients a flat list of members and scores.
+	resp3 := w.Protocol() >= resp.RESP3
+	if resp3 {
+		w.Array(len(members))
+	} else {
+		w.Array(len(members) * 2)
+	}
 	for _, member := range members {
-		if withScores {
-			scoreStr := fmt.Sprintf("%.17g", member.Score)
-			results += fmt.Sprintf("$%d\r\n%s\r\n", len(member.Member), member.Member)
-			results += fmt.Sprintf("$%d\r\n%s\r\n", len(scoreStr), scoreStr)
-		} else {
-			results += fmt.Sprintf("$%d\r\n%s\r\n", len(member.Member), member.Member)
+		if resp3 {
+			w.Array(2)
 		}
+		w.BulkString(member.Member)
+		w.Double(member.Score)
 	}
-
-	return []byte(results)
 }
 
 var ZRangeSpec = &CommandSpec{
@@ -101,11 +115,7 @@ var ZRangeSpec = &CommandSpec{
 	},
 }
 
-func handleZRem(cmd *Command, s *store.Store) []byte {
-	if len(cmd.Args) < 2 {
-		return fmt.Appendf(nil, "ERR wrong number of arguments for '%s' command\r\n", cmd.Name)
-	}
-
+func handleZRem(client Client, cmd *Command, s *store.Store, w *resp.Writer) {
 	key := cmd.Args[0]
 	members := cmd.Args[1:]
 	membersStr := make([]string, len(members))
@@ -113,9 +123,13 @@ func handleZRem(cmd *Command, s *store.Store) []byte {
 		membersStr[i] = string(member)
 	}
 
-	count := s.ZRem(string(key), membersStr)
+	count, err := s.ZRem(string(key), membersStr)
+	if err != nil {
+		w.Error(err.Error())
+		return
+	}
 
-	return fmt.Appendf(nil, ":%d\r\n", count)
+	w.Int(int64(count))
 }
 
 var ZRemSpec = &CommandSpec{
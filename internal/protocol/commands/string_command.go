@@ -1,29 +1,36 @@
 package commands
 
 import (
-	"fmt"
+	"errors"
+	"math"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
 	"github.com/teguhkurnia/redis-like/internal/store"
 )
 
-func handleGet(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) != 1 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", cmd.Name)
+func handleGet(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
+	value, found, err := store.Get(string(cmd.Args[0]))
+	if err != nil {
+		w.Error(err.Error())
+		return
 	}
-	value, found := store.Get(string(cmd.Args[0]))
 	if !found {
-		return fmt.Appendf(nil, "$-1\r\n")
+		w.Null()
+		return
 	}
 
 	// parse to integer
 	number, err := strconv.Atoi(value)
 	if err != nil {
-		return fmt.Appendf(nil, "$%d\r\n%s\r\n", len(value), value)
+		w.BulkString(value)
+		return
 	}
 
 	// return integer response
-	return fmt.Appendf(nil, ":%d\r\n", number)
+	w.Int(int64(number))
 }
 
 var GetSpec = &CommandSpec{
@@ -38,30 +45,139 @@ var GetSpec = &CommandSpec{
 	},
 }
 
-func handleSet(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) != 2 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", cmd.Name)
+// parseSetOptions parses the options following SET key value. Relative
+// expirations are resolved against now.
+func parseSetOptions(args [][]byte, now time.Time) (store.SetOptions, error) {
+	var opts store.SetOptions
+	var expire string
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch option {
+		case "NX", "XX":
+			if opts.Condition != store.SetAlways {
+				return opts, errSyntax
+			}
+			opts.Condition = store.SetNX
+			if option == "XX" {
+				opts.Condition = store.SetXX
+			}
+		case "GET":
+			opts.Get = true
+		case "KEEPTTL":
+			if expire != "" {
+				return opts, errSyntax
+			}
+			opts.KeepTTL = true
+			expire = option
+		case "EX", "PX", "EXAT", "PXAT":
+			if expire != "" || i+1 == len(args) {
+				return opts, errSyntax
+			}
+			i++
+			value, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return opts, errNotInteger
+			}
+			unit := int64(1)
+			if option == "EX" || option == "EXAT" {
+				unit = 1000
+			}
+			if value <= 0 || value > math.MaxInt64/unit {
+				return opts, errInvalidSetExpire
+			}
+			milliseconds := value * unit
+			if option == "EX" || option == "PX" {
+				if milliseconds > math.MaxInt64-now.UnixMilli() {
+					return opts, errInvalidSetExpire
+				}
+				milliseconds += now.UnixMilli()
+			}
+			opts.ExpireAt = time.UnixMilli(milliseconds)
+			expire = option
+		default:
+			return opts, errSyntax
+		}
 	}
-	store.Set(string(cmd.Args[0]), string(cmd.Args[1]))
-	return []byte("+OK\r\n")
+	return opts, nil
+}
+
+var (
+	errSyntax           = errors.New("syntax error")
+	errNotInteger       = errors.New("value is not an integer or out of range")
+	errInvalidSetExpire = errors.New("invalid expire time in 'set' command")
+)
+
+func handleSet(client Client, cmd *Command, s *store.Store, w *resp.Writer) {
+	opts, err := parseSetOptions(cmd.Args[2:], time.Now())
+	if err != nil {
+		w.Errorf("ERR %s", err)
+		return
+	}
+
+	result, err := s.SetWithOptions(string(cmd.Args[0]), string(cmd.Args[1]), opts)
+	if err != nil {
+		w.Error(err.Error())
+		return
+	}
+	cmd.skipped = !result.Applied
+	if opts.Get {
+		if !result.Existed {
+			w.Null()
+			return
+		}
+		w.BulkString(result.Old)
+		return
+	}
+	if !result.Applied {
+		w.Null()
+		return
+	}
+	w.Status("OK")
+}
+
+// propagateSet logs SET with the deadline the key actually got as PXAT, so
+// a relative EX or PX is not extended when the AOF is replayed. GET only
+// affects the reply and is dropped. A SET whose NX or XX condition failed
+// changed nothing and is not logged.
+func propagateSet(cmd *Command, s *store.Store) []*Command {
+	if cmd.skipped {
+		return nil
+	}
+	key := string(cmd.Args[0])
+	at, exists := s.ExpireTime(key)
+	if !exists {
+		return []*Command{{Name: "DEL", Args: [][]byte{cmd.Args[0]}}}
+	}
+
+	args := [][]byte{cmd.Args[0], cmd.Args[1]}
+	if opts, err := parseSetOptions(cmd.Args[2:], time.Now()); err == nil {
+		switch opts.Condition {
+		case store.SetNX:
+			args = append(args, []byte("NX"))
+		case store.SetXX:
+			args = append(args, []byte("XX"))
+		}
+	}
+	if !at.IsZero() {
+		args = append(args, []byte("PXAT"), strconv.AppendInt(nil, at.UnixMilli(), 10))
+	}
+	return []*Command{{Name: "SET", Args: args}}
 }
 
 var SetSpec = &CommandSpec{
-	Handler:  handleSet,
-	Arity:    3,
-	Flags:    []string{"write"},
-	FirstKey: 1,
-	LastKey:  1,
-	KeyStep:  1,
+	Handler:   handleSet,
+	Propagate: propagateSet,
+	Arity:     -3, // key, value and optional NX|XX, GET, EX|PX|EXAT|PXAT|KEEPTTL
+	Flags:     []string{"write", "deny-oom"},
+	FirstKey:  1,
+	LastKey:   1,
+	KeyStep:   1,
 	Documentation: map[string]any{
-		"summary": "Sets the value of a key.",
+		"summary": "Sets the value of a key, optionally only if it does or does not exist, with an expiration time.",
 	},
 }
 
-func handleDel(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) < 1 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", cmd.Name)
-	}
+func handleDel(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
 	count := 0
 	for _, key := range cmd.Args {
 		deleted := store.Del(string(key))
@@ -70,12 +186,12 @@ func handleDel(cmd *Command, store *store.Store) []byte {
 		}
 	}
 
-	return fmt.Appendf(nil, ":%d\r\n", count)
+	w.Int(int64(count))
 }
 
 var DelSpec = &CommandSpec{
 	Handler:  handleDel,
-	Arity:    -1,
+	Arity:    -2,
 	Flags:    []string{"write"},
 	FirstKey: 1,
 	LastKey:  -1,
@@ -85,21 +201,19 @@ var DelSpec = &CommandSpec{
 	},
 }
 
-func handleIncr(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) != 1 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", cmd.Name)
-	}
-	value, ok := store.Incr(string(cmd.Args[0]))
-	if !ok {
-		return fmt.Appendf(nil, "-ERR value is not an integer\r\n")
+func handleIncr(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
+	value, err := store.Incr(string(cmd.Args[0]))
+	if err != nil {
+		w.Error(err.Error())
+		return
 	}
-	return fmt.Appendf(nil, ":%d\r\n", value)
+	w.Int(value)
 }
 
 var IncrSpec = &CommandSpec{
 	Handler:  handleIncr,
 	Arity:    2,
-	Flags:    []string{"write"},
+	Flags:    []string{"write", "deny-oom"},
 	FirstKey: 1,
 	LastKey:  1,
 	KeyStep:  1,
@@ -108,22 +222,20 @@ var IncrSpec = &CommandSpec{
 	},
 }
 
-func handleDecr(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) != 1 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", cmd.Name)
-	}
-	value, ok := store.Decr(string(cmd.Args[0]))
-	if !ok {
-		return fmt.Appendf(nil, "-ERR value is not an integer\r\n")
+func handleDecr(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
+	value, err := store.Decr(string(cmd.Args[0]))
+	if err != nil {
+		w.Error(err.Error())
+		return
 	}
 
-	return fmt.Appendf(nil, ":%d\r\n", value)
+	w.Int(value)
 }
 
 var DecrSpec = &CommandSpec{
 	Handler:  handleDecr,
 	Arity:    2,
-	Flags:    []string{"write"},
+	Flags:    []string{"write", "deny-oom"},
 	FirstKey: 1,
 	LastKey:  1,
 	KeyStep:  1,
@@ -2,51 +2,202 @@ package commands
 
 import (
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
 	"github.com/teguhkurnia/redis-like/internal/store"
 )
 
-func handleExpire(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) != 2 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", cmd.Name)
+// handleExpireFamily implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT. The
+// time argument is counted in unit, and is either relative to now or an
+// absolute Unix timestamp.
+func handleExpireFamily(cmd *Command, s *store.Store, w *resp.Writer, unit time.Duration, absolute bool) {
+	key := string(cmd.Args[0])
+	value, err := strconv.ParseInt(string(cmd.Args[1]), 10, 64)
+	if err != nil {
+		w.Error("ERR value is not an integer or out of range")
+		return
+	}
+	cond, err := parseExpireCondition(cmd.Args[2:])
+	if err != nil {
+		w.Errorf("ERR %s", err)
+		return
+	}
+
+	perMilli := int64(unit / time.Millisecond)
+	if value > math.MaxInt64/perMilli || value < math.MinInt64/perMilli {
+		w.Errorf("ERR invalid expire time in '%s' command", cmd.Name)
+		return
+	}
+	milliseconds := value * perMilli
+	if !absolute {
+		now := time.Now().UnixMilli()
+		if milliseconds > 0 && milliseconds > math.MaxInt64-now {
+			w.Errorf("ERR invalid expire time in '%s' command", cmd.Name)
+			return
+		}
+		milliseconds += now
 	}
+
+	updated := s.ExpireAt(key, time.UnixMilli(milliseconds), cond)
+	w.Int(int64(updated))
+}
+
+func parseExpireCondition(args [][]byte) (store.ExpireCondition, error) {
+	var nx, xx, gt, lt bool
+	for _, arg := range args {
+		switch strings.ToUpper(string(arg)) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		default:
+			return 0, fmt.Errorf("Unsupported option %s", arg)
+		}
+	}
+
+	switch {
+	case nx && (xx || gt || lt):
+		return 0, fmt.Errorf("NX and XX, GT or LT options at the same time are not compatible")
+	case gt && lt:
+		return 0, fmt.Errorf("GT and LT options at the same time are not compatible")
+	case nx:
+		return store.ExpireNX, nil
+	case gt:
+		return store.ExpireGT, nil
+	case lt:
+		return store.ExpireLT, nil
+	case xx:
+		return store.ExpireXX, nil
+	}
+	return store.ExpireAlways, nil
+}
+
+// propagateExpire logs the deadline the key actually got, so replaying the
+// AOF later does not push it further into the future.
+func propagateExpire(cmd *Command, store *store.Store) []*Command {
 	key := string(cmd.Args[0])
-	seconds, err := strconv.Atoi(string(cmd.Args[1]))
-	if err != nil || seconds < 0 {
-		return fmt.Appendf(nil, "-ERR invalid expire time in '%s' command\r\n", cmd.Name)
+	at, exists := store.ExpireTime(key)
+	if !exists {
+		return []*Command{{Name: "DEL", Args: [][]byte{cmd.Args[0]}}}
 	}
+	if at.IsZero() {
+		return nil
+	}
+	return []*Command{PExpireAtCommand(key, at)}
+}
 
-	store.Expire(key, seconds)
-	return []byte("+OK\r\n")
+// PExpireAtCommand builds the command that sets key to expire at the given
+// time, as written to the AOF.
+func PExpireAtCommand(key string, at time.Time) *Command {
+	return &Command{
+		Name: "PEXPIREAT",
+		Args: [][]byte{[]byte(key), strconv.AppendInt(nil, at.UnixMilli(), 10)},
+	}
+}
+
+func handleExpire(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
+	handleExpireFamily(cmd, store, w, time.Second, false)
 }
 
 var ExpireSpec = &CommandSpec{
-	Handler:  handleExpire,
-	Arity:    2, // Arity is 2 because it expects a key and a TTL
-	Flags:    []string{"write"},
+	Handler:   handleExpire,
+	Propagate: propagateExpire,
+	Arity:     -3, // key, seconds and optional NX|XX|GT|LT
+	Flags:     []string{"write", "fast"},
+	FirstKey:  1,
+	LastKey:   1,
+	KeyStep:   1,
+	Documentation: map[string]any{
+		"summary": "Sets the expiration time of a key in seconds.",
+	},
+}
+
+func handlePExpire(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
+	handleExpireFamily(cmd, store, w, time.Millisecond, false)
+}
+
+var PExpireSpec = &CommandSpec{
+	Handler:   handlePExpire,
+	Propagate: propagateExpire,
+	Arity:     -3,
+	Flags:     []string{"write", "fast"},
+	FirstKey:  1,
+	LastKey:   1,
+	KeyStep:   1,
+	Documentation: map[string]any{
+		"summary": "Sets the expiration time of a key in milliseconds.",
+	},
+}
+
+func handleExpireAt(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
+	handleExpireFamily(cmd, store, w, time.Second, true)
+}
+
+var ExpireAtSpec = &CommandSpec{
+	Handler:   handleExpireAt,
+	Propagate: propagateExpire,
+	Arity:     -3,
+	Flags:     []string{"write", "fast"},
+	FirstKey:  1,
+	LastKey:   1,
+	KeyStep:   1,
+	Documentation: map[string]any{
+		"summary": "Sets the expiration time of a key to a Unix timestamp.",
+	},
+}
+
+func handlePExpireAt(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
+	handleExpireFamily(cmd, store, w, time.Millisecond, true)
+}
+
+var PExpireAtSpec = &CommandSpec{
+	Handler:   handlePExpireAt,
+	Propagate: propagateExpire,
+	Arity:     -3,
+	Flags:     []string{"write", "fast"},
+	FirstKey:  1,
+	LastKey:   1,
+	KeyStep:   1,
+	Documentation: map[string]any{
+		"summary": "Sets the expiration time of a key to a Unix milliseconds timestamp.",
+	},
+}
+
+func handlePersist(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
+	w.Int(int64(store.Persist(string(cmd.Args[0]))))
+}
+
+var PersistSpec = &CommandSpec{
+	Handler:  handlePersist,
+	Arity:    2,
+	Flags:    []string{"write", "fast"},
 	FirstKey: 1,
 	LastKey:  1,
 	KeyStep:  1,
 	Documentation: map[string]any{
-		"summary": "Sets the expiration time of a key.",
+		"summary": "Removes the expiration time of a key.",
 	},
 }
 
-func handleTTL(cmd *Command, store *store.Store) []byte {
-	if len(cmd.Args) != 1 {
-		return fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", cmd.Name)
-	}
+func handleTTL(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
 	key := string(cmd.Args[0])
 
 	ttl := store.TTL(key)
-	return fmt.Appendf(nil, ":%d\r\n", ttl)
+	w.Int(int64(ttl))
 }
 
 var TTLSpec = &CommandSpec{
 	Handler:  handleTTL,
-	Arity:    2, // Arity is  1 because it expects a key
-	Flags:    []string{"readonly"},
+	Arity:    2, // Arity is 2 because it expects a key
+	Flags:    []string{"readonly", "fast"},
 	FirstKey: 1,
 	LastKey:  1,
 	KeyStep:  1,
@@ -54,3 +205,66 @@ var TTLSpec = &CommandSpec{
 		"summary": "Returns the time (seconds) to live for a key.",
 	},
 }
+
+func handlePTTL(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
+	w.Int(store.PTTL(string(cmd.Args[0])))
+}
+
+var PTTLSpec = &CommandSpec{
+	Handler:  handlePTTL,
+	Arity:    2,
+	Flags:    []string{"readonly", "fast"},
+	FirstKey: 1,
+	LastKey:  1,
+	KeyStep:  1,
+	Documentation: map[string]any{
+		"summary": "Returns the time (milliseconds) to live for a key.",
+	},
+}
+
+// handleExpireTimeFamily implements EXPIRETIME and PEXPIRETIME, which return
+// the absolute deadline, -1 for keys without one and -2 for missing keys.
+func handleExpireTimeFamily(cmd *Command, s *store.Store, w *resp.Writer, unit time.Duration) {
+	at, exists := s.ExpireTime(string(cmd.Args[0]))
+	if !exists {
+		w.Int(-2)
+		return
+	}
+	if at.IsZero() {
+		w.Int(-1)
+		return
+	}
+	w.Int(at.UnixMilli() / int64(unit/time.Millisecond))
+}
+
+func handleExpireTime(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
+	handleExpireTimeFamily(cmd, store, w, time.Second)
+}
+
+var ExpireTimeSpec = &CommandSpec{
+	Handler:  handleExpireTime,
+	Arity:    2,
+	Flags:    []string{"readonly", "fast"},
+	FirstKey: 1,
+	LastKey:  1,
+	KeyStep:  1,
+	Documentation: map[string]any{
+		"summary": "Returns the expiration time of a key as a Unix timestamp.",
+	},
+}
+
+func handlePExpireTime(client Client, cmd *Command, store *store.Store, w *resp.Writer) {
+	handleExpireTimeFamily(cmd, store, w, time.Millisecond)
+}
+
+var PExpireTimeSpec = &CommandSpec{
+	Handler:  handlePExpireTime,
+	Arity:    2,
+	Flags:    []string{"readonly", "fast"},
+	FirstKey: 1,
+	LastKey:  1,
+	KeyStep:  1,
+	Documentation: map[string]any{
+		"summary": "Returns the expiration time of a key as a Unix milliseconds timestamp.",
+	},
+}
@@ -0,0 +1,31 @@
+package protocol
+
+import (
+	"slices"
+	"time"
+
+	"github.com/teguhkurnia/redis-like/internal/protocol/commands"
+)
+
+// MonitorFunc receives a command sent by client, at the time it was handled.
+type MonitorFunc func(client commands.Client, cmd *commands.Command, start time.Time)
+
+// SetMonitor makes HandleCommand pass every command the clients of the
+// server send to fn before running it, as MONITOR shows them, or stops when
+// fn is nil. Commands refused without running are passed too, and admin
+// commands are left out, like in Redis.
+func (s *Stats) SetMonitor(fn MonitorFunc) {
+	if fn == nil {
+		s.monitor.Store(nil)
+		return
+	}
+	s.monitor.Store(&fn)
+}
+
+func (s *Stats) feedMonitor(spec *commands.CommandSpec, client commands.Client, cmd *commands.Command, start time.Time) {
+	fn := s.monitor.Load()
+	if fn == nil || (spec != nil && slices.Contains(spec.Flags, "admin")) {
+		return
+	}
+	(*fn)(client, cmd, start)
+}
@@ -0,0 +1,141 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+)
+
+// maxInlineLen is the longest inline command accepted, like
+// PROTO_INLINE_MAX_SIZE in Redis.
+const maxInlineLen = 64 * 1024
+
+// ParseRequest reads the next request from a client. Requests are normally
+// flat RESP arrays of bulk strings, but like Redis anything that does not start with '*' is read
+// as an inline command: a single line of space-separated words, so commands
+// can be typed by hand over telnet.
+func ParseRequest(reader *bufio.Reader) (RESPValue, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return RESPValue{}, err
+	}
+	if first[0] == '*' {
+		return parseMultiBulk(reader)
+	}
+	return parseInline(reader)
+}
+
+func parseInline(reader *bufio.Reader) (RESPValue, error) {
+	line, err := readLine(reader, maxInlineLen)
+	if err == errLineTooLong {
+		return RESPValue{}, protocolErrorf("too big inline request")
+	}
+	if err != nil {
+		return RESPValue{}, err
+	}
+	// Telnet ends lines with CRLF, but netcat and friends only send LF.
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+
+	words, err := SplitArgs(line)
+	if err != nil {
+		return RESPValue{}, err
+	}
+	array := make([]RESPValue, len(words))
+	for i, word := range words {
+		array[i] = RESPValue{Type: '$', Bulk: word}
+	}
+	return RESPValue{Type: '*', Array: array}, nil
+}
+
+// SplitArgs splits an inline command or a config file line into words the
+// way redis-cli does. Words are separated by spaces and may be quoted: double
+// quotes understand the escapes \n, \r, \t, \b, \a, \\, \" and \xHH, single
+// quotes only \'. A closing quote must be followed by a space or the end of
+// the line.
+func SplitArgs(line []byte) ([][]byte, error) {
+	var words [][]byte
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return words, nil
+		}
+
+		word := []byte{}
+		inDouble, inSingle := false, false
+		for done := false; !done; {
+			if i == len(line) {
+				if inDouble || inSingle {
+					return nil, protocolErrorf("unbalanced quotes in request")
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case inDouble:
+				switch {
+				case c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					b, _ := strconv.ParseUint(string(line[i+2:i+4]), 16, 8)
+					word = append(word, byte(b))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						word = append(word, '\n')
+					case 'r':
+						word = append(word, '\r')
+					case 't':
+						word = append(word, '\t')
+					case 'b':
+						word = append(word, '\b')
+					case 'a':
+						word = append(word, '\a')
+					default:
+						word = append(word, line[i])
+					}
+				case c == '"':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, protocolErrorf("unbalanced quotes in request")
+					}
+					done = true
+				default:
+					word = append(word, c)
+				}
+			case inSingle:
+				switch {
+				case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					word = append(word, '\'')
+				case c == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, protocolErrorf("unbalanced quotes in request")
+					}
+					done = true
+				default:
+					word = append(word, c)
+				}
+			case isSpace(c):
+				done = true
+			case c == '"':
+				inDouble = true
+			case c == '\'':
+				inSingle = true
+			default:
+				word = append(word, c)
+			}
+			i++
+		}
+		words = append(words, word)
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
@@ -2,28 +2,98 @@ package parser
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"strconv"
 	"strings"
 
 	"github.com/teguhkurnia/redis-like/internal/protocol/commands"
 )
 
+// Limits on what a client may send, matching the defaults of Redis.
+const (
+	// maxBulkLen is the largest bulk string accepted (proto-max-bulk-len).
+	maxBulkLen = 512 * 1024 * 1024
+	// maxMultiBulkLen is the largest number of elements in an array.
+	maxMultiBulkLen = 1024 * 1024
+)
+
+// ProtocolError reports input that does not follow RESP. Unlike I/O errors,
+// the connection cannot recover from it, since the position of the next
+// request is unknown.
+type ProtocolError struct {
+	msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.msg
+}
+
+func protocolErrorf(format string, args ...any) error {
+	return &ProtocolError{msg: fmt.Sprintf(format, args...)}
+}
+
+// RESPValue is a value of any RESP2 or RESP3 type. Which fields are set
+// depends on Type:
+//
+//   - '+' simple string, '-' error and '(' big number: Str
+//   - '$' bulk string and '!' bulk error: Bulk, nil for a null bulk string
+//   - '=' verbatim string: Bulk, with its format such as "txt" in Str
+//   - ':' integer: Int
+//   - ',' double: Double
+//   - '#' boolean: Bool
+//   - '_' null: nothing
+//   - '*' array, '~' set and '>' push: Array, nil for a null array
+//   - '%' map: Array, holding keys and values in turn
 type RESPValue struct {
-	Type  byte
-	Str   string
-	Bulk  []byte
-	Array []RESPValue
+	Type   byte
+	Str    string
+	Bulk   []byte
+	Int    int64
+	Double float64
+	Bool   bool
+	Array  []RESPValue
+}
+
+var errLineTooLong = errors.New("line too long")
+
+// readLine reads up to and including the next '\n', giving up once the line
+// is longer than limit so a client cannot make us buffer without bound.
+func readLine(reader *bufio.Reader, limit int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > limit {
+			return nil, errLineTooLong
+		}
+		line = append(line, chunk...)
+		switch err {
+		case nil:
+			return line, nil
+		case bufio.ErrBufferFull:
+			continue
+		case io.EOF:
+			if len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		return nil, err
+	}
 }
 
 func ParseNextValue(reader *bufio.Reader) (RESPValue, error) {
-	line, err := reader.ReadBytes('\n')
+	line, err := readLine(reader, maxInlineLen)
+	if err == errLineTooLong {
+		return RESPValue{}, protocolErrorf("too big count string")
+	}
 	if err != nil {
 		return RESPValue{}, err
 	}
-	if len(line) < 2 {
-		return RESPValue{}, fmt.Errorf("invalid RESP line")
+	if !bytes.HasSuffix(line, []byte("\r\n")) || len(line) < 3 {
+		return RESPValue{}, protocolErrorf("invalid line %q", line)
 	}
 	line = line[:len(line)-2] // Trim trailing \r\n
 
@@ -42,46 +112,137 @@ func ParseNextValue(reader *bufio.Reader) (RESPValue, error) {
 			return RESPValue{Type: '+', Str: unquoted}, nil
 		}
 		return RESPValue{Type: '+', Str: str}, nil
-	case '$': // Bulk String
-		length, err := strconv.Atoi(string(valueContent))
+	case '-', '(': // Simple Error, Big Number
+		if valueType == '(' {
+			if _, ok := new(big.Int).SetString(string(valueContent), 10); !ok {
+				return RESPValue{}, protocolErrorf("invalid big number")
+			}
+		}
+		return RESPValue{Type: valueType, Str: string(valueContent)}, nil
+	case ':': // Integer
+		n, err := strconv.ParseInt(string(valueContent), 10, 64)
+		if err != nil {
+			return RESPValue{}, protocolErrorf("invalid integer")
+		}
+		return RESPValue{Type: ':', Int: n}, nil
+	case ',': // Double
+		f, err := strconv.ParseFloat(string(valueContent), 64)
 		if err != nil {
-			return RESPValue{}, fmt.Errorf("invalid bulk string length: %s", valueContent)
+			return RESPValue{}, protocolErrorf("invalid double")
+		}
+		return RESPValue{Type: ',', Double: f}, nil
+	case '#': // Boolean
+		if len(valueContent) != 1 || (valueContent[0] != 't' && valueContent[0] != 'f') {
+			return RESPValue{}, protocolErrorf("invalid boolean")
+		}
+		return RESPValue{Type: '#', Bool: valueContent[0] == 't'}, nil
+	case '_': // Null
+		if len(valueContent) != 0 {
+			return RESPValue{}, protocolErrorf("invalid null")
+		}
+		return RESPValue{Type: '_'}, nil
+	case '$', '!', '=': // Bulk String, Bulk Error, Verbatim String
+		length, err := strconv.Atoi(string(valueContent))
+		if err != nil || length < -1 || length > maxBulkLen || (length == -1 && valueType != '$') {
+			return RESPValue{}, protocolErrorf("invalid bulk length")
 		}
 		if length == -1 {
 			return RESPValue{Type: '$'}, nil // Null Bulk String
 		}
 		buf := make([]byte, length+2)
 		if _, err := io.ReadFull(reader, buf); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
 			return RESPValue{}, err
 		}
-		return RESPValue{Type: '$', Bulk: buf[:length]}, nil
-	case '*': // Array
+		if buf[length] != '\r' || buf[length+1] != '\n' {
+			return RESPValue{}, protocolErrorf("bulk string is not terminated by CRLF")
+		}
+		if valueType == '=' {
+			if length < 4 || buf[3] != ':' {
+				return RESPValue{}, protocolErrorf("invalid verbatim string")
+			}
+			return RESPValue{Type: '=', Str: string(buf[:3]), Bulk: buf[4:length]}, nil
+		}
+		return RESPValue{Type: valueType, Bulk: buf[:length]}, nil
+	case '*', '~', '>', '%': // Array, Set, Push, Map
 		count, err := strconv.Atoi(string(valueContent))
-		if err != nil {
-			return RESPValue{}, fmt.Errorf("invalid array count: %s", valueContent)
+		if err != nil || count < -1 || count > maxMultiBulkLen || (count == -1 && valueType != '*') {
+			return RESPValue{}, protocolErrorf("invalid multibulk length")
+		}
+		if count == -1 {
+			return RESPValue{Type: '*'}, nil // Null Array
+		}
+		if valueType == '%' {
+			count *= 2
 		}
 		array := make([]RESPValue, count)
 		for i := 0; i < count; i++ {
 			val, err := ParseNextValue(reader)
 			if err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
 				return RESPValue{}, err
 			}
 			array[i] = val
 		}
-		return RESPValue{Type: '*', Array: array}, nil
+		return RESPValue{Type: valueType, Array: array}, nil
+	}
+	return RESPValue{}, protocolErrorf("unsupported RESP type %q", valueType)
+}
+
+// parseMultiBulk reads a request sent as a RESP array. Like in Redis, its
+// elements must be bulk strings, so a client cannot nest arrays without
+// bound and exhaust the stack.
+func parseMultiBulk(reader *bufio.Reader) (RESPValue, error) {
+	line, err := readLine(reader, maxInlineLen)
+	if err == errLineTooLong {
+		return RESPValue{}, protocolErrorf("too big count string")
+	}
+	if err != nil {
+		return RESPValue{}, err
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) || len(line) < 3 {
+		return RESPValue{}, protocolErrorf("invalid line %q", line)
+	}
+	count, err := strconv.Atoi(string(line[1 : len(line)-2]))
+	if err != nil || count < -1 || count > maxMultiBulkLen {
+		return RESPValue{}, protocolErrorf("invalid multibulk length")
+	}
+	if count == -1 {
+		return RESPValue{Type: '*'}, nil
+	}
+
+	array := make([]RESPValue, count)
+	for i := range array {
+		next, err := reader.Peek(1)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return RESPValue{}, err
+		}
+		if next[0] != '$' {
+			return RESPValue{}, protocolErrorf("expected '$', got '%c'", next[0])
+		}
+		if array[i], err = ParseNextValue(reader); err != nil {
+			return RESPValue{}, err
+		}
 	}
-	return RESPValue{}, fmt.Errorf("unsupported RESP type: %c", valueType)
+	return RESPValue{Type: '*', Array: array}, nil
 }
 
 // ToCommand converts a RESPValue into our Command struct
 func (v RESPValue) ToCommand() (*commands.Command, error) {
 	if v.Type != '*' {
-		return nil, fmt.Errorf("invalid command: not a RESP array")
+		return nil, protocolErrorf("expected '*', got '%c'", v.Type)
 	}
 	if len(v.Array) == 0 {
-		return nil, fmt.Errorf("invalid command: empty array")
+		return nil, protocolErrorf("empty command")
 	}
-	
+
 	// Get command name from first element
 	var cmdName string
 	switch v.Array[0].Type {
@@ -90,13 +251,13 @@ func (v RESPValue) ToCommand() (*commands.Command, error) {
 	case '+':
 		cmdName = v.Array[0].Str
 	default:
-		return nil, fmt.Errorf("invalid command name type: %c", v.Array[0].Type)
+		return nil, protocolErrorf("expected '$', got '%c'", v.Array[0].Type)
 	}
-	
+
 	cmd := &commands.Command{
 		Name: strings.ToUpper(cmdName),
 	}
-	
+
 	// Process arguments
 	for i := 1; i < len(v.Array); i++ {
 		var arg []byte
@@ -106,7 +267,7 @@ func (v RESPValue) ToCommand() (*commands.Command, error) {
 		case '+':
 			arg = []byte(v.Array[i].Str)
 		default:
-			return nil, fmt.Errorf("invalid argument type: %c", v.Array[i].Type)
+			return nil, protocolErrorf("expected '$', got '%c'", v.Array[i].Type)
 		}
 		cmd.Args = append(cmd.Args, arg)
 	}
@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parse(input string) (RESPValue, error) {
+	return ParseRequest(bufio.NewReader(strings.NewReader(input)))
+}
+
+func TestParseCommand(t *testing.T) {
+	value, err := parse("*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$5\r\nva\r\nl\r\n")
+	require.NoError(t, err)
+	cmd, err := value.ToCommand()
+	require.NoError(t, err)
+	assert.Equal(t, "SET", cmd.Name)
+	assert.Equal(t, [][]byte{[]byte("key"), []byte("va\r\nl")}, cmd.Args)
+}
+
+func TestParseProtocolErrors(t *testing.T) {
+	inputs := []string{
+		"*1\n",
+		"\r\n",
+		"*abc\r\n",
+		"*-2\r\n",
+		"*2000000\r\n",
+		"*1\r\n$x\r\n",
+		"*1\r\n$-5\r\n",
+		"*1\r\n$3\r\nGETXX",
+		"*1\r\n:1\r\n",
+		"*2\r\n$3\r\nGET\r\n*1\r\n$3\r\nkey\r\n",
+		"*1\r\n+PING\r\n",
+		// Nesting is refused before it can exhaust the stack.
+		strings.Repeat("*1\r\n", 1_000_000),
+	}
+	for _, input := range inputs {
+		value, err := parse(input)
+		if err == nil {
+			_, err = value.ToCommand()
+		}
+		var protoErr *ProtocolError
+		assert.ErrorAs(t, err, &protoErr, "%q", input[:min(len(input), 32)])
+	}
+}
+
+func TestParseEOF(t *testing.T) {
+	_, err := parse("")
+	assert.ErrorIs(t, err, io.EOF)
+
+	// A request cut short is not a clean disconnect.
+	for _, input := range []string{"*2\r", "*2\r\n", "*2\r\n$3\r\nGET\r\n", "*1\r\n$3\r\nGE"} {
+		_, err := parse(input)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF, "%q", input)
+	}
+}
+
+func TestParseInline(t *testing.T) {
+	cases := map[string][]string{
+		"SET foo \"bar baz\"\r\n":          {"SET", "foo", "bar baz"},
+		"  get   foo\n":                    {"get", "foo"},
+		"SET k \"a\\tb\\x41\\\"\\\\\"\r\n": {"SET", "k", "a\tbA\"\\"},
+		"SET k 'it\\'s \"raw\"'\r\n":       {"SET", "k", "it's \"raw\""},
+		"SET k \"\"\r\n":                   {"SET", "k", ""},
+		"\r\n":                             {},
+	}
+	for input, want := range cases {
+		value, err := parse(input)
+		require.NoError(t, err, "%q", input)
+		got := []string{}
+		for _, word := range value.Array {
+			got = append(got, string(word.Bulk))
+		}
+		assert.Equal(t, want, got, "%q", input)
+	}
+}
+
+func TestParseInlineErrors(t *testing.T) {
+	inputs := []string{
+		"SET k \"unterminated\r\n",
+		"SET k 'unterminated\r\n",
+		"SET k \"quoted\"trailing\r\n",
+		strings.Repeat("a", maxInlineLen+1) + "\r\n",
+	}
+	for _, input := range inputs {
+		_, err := parse(input)
+		var protoErr *ProtocolError
+		assert.ErrorAs(t, err, &protoErr, "%q", input[:min(len(input), 32)])
+	}
+
+	// An inline command must be a whole line.
+	_, err := parse("GET foo")
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestParseRESP3(t *testing.T) {
+	value, err := ParseNextValue(bufio.NewReader(strings.NewReader("%2\r\n+a\r\n:1\r\n$1\r\nb\r\n~3\r\n,1.5\r\n#t\r\n_\r\n")))
+	require.NoError(t, err)
+	assert.Equal(t, byte('%'), value.Type)
+	require.Len(t, value.Array, 4)
+	assert.Equal(t, int64(1), value.Array[1].Int)
+	set := value.Array[3]
+	assert.Equal(t, byte('~'), set.Type)
+	assert.Equal(t, 1.5, set.Array[0].Double)
+	assert.True(t, set.Array[1].Bool)
+	assert.Equal(t, byte('_'), set.Array[2].Type)
+
+	value, err = ParseNextValue(bufio.NewReader(strings.NewReader("=8\r\ntxt:info\r\n")))
+	require.NoError(t, err)
+	assert.Equal(t, "txt", value.Str)
+	assert.Equal(t, "info", string(value.Bulk))
+
+	value, err = ParseNextValue(bufio.NewReader(strings.NewReader("(3492890328409238509324850943850943825024385\r\n")))
+	require.NoError(t, err)
+	assert.Equal(t, "3492890328409238509324850943850943825024385", value.Str)
+
+	for _, input := range []string{"#x\r\n", ",abc\r\n", "(12a\r\n", "%-1\r\n", "=3\r\ntxt\r\n"} {
+		_, err := ParseNextValue(bufio.NewReader(strings.NewReader(input)))
+		var protoErr *ProtocolError
+		assert.ErrorAs(t, err, &protoErr, "%q", input)
+	}
+}
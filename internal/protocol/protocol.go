@@ -1,12 +1,13 @@
 package protocol
 
 import (
-	"fmt"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/teguhkurnia/redis-like/internal/log"
 	"github.com/teguhkurnia/redis-like/internal/protocol/commands"
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
 	"github.com/teguhkurnia/redis-like/internal/store"
 )
 
@@ -15,7 +16,10 @@ var commandTable = make(map[string]*commands.CommandSpec)
 func init() {
 	// Connection commands
 	commandTable["PING"] = commands.PingSpec
+	commandTable["HELLO"] = commands.HelloSpec
 	commandTable["COMMAND"] = CommandHandlerSpec
+	commandTable["LATENCY"] = LatencySpec
+	commandTable["SLOWLOG"] = SlowlogSpec
 
 	// String commands
 	commandTable["GET"] = commands.GetSpec
@@ -26,7 +30,14 @@ func init() {
 
 	// TIME commands
 	commandTable["EXPIRE"] = commands.ExpireSpec
+	commandTable["PEXPIRE"] = commands.PExpireSpec
+	commandTable["EXPIREAT"] = commands.ExpireAtSpec
+	commandTable["PEXPIREAT"] = commands.PExpireAtSpec
+	commandTable["PERSIST"] = commands.PersistSpec
 	commandTable["TTL"] = commands.TTLSpec
+	commandTable["PTTL"] = commands.PTTLSpec
+	commandTable["EXPIRETIME"] = commands.ExpireTimeSpec
+	commandTable["PEXPIRETIME"] = commands.PExpireTimeSpec
 
 	// Key existence commands
 	commandTable["EXISTS"] = commands.ExistsSpec
@@ -57,32 +68,151 @@ func init() {
 	commandTable["ZRANGE"] = commands.ZRangeSpec
 }
 
-func HandleCommand(cmd *commands.Command, store *store.Store, log *log.Log, fromLog bool) []byte {
+// RegisterCommand adds a command whose handler needs state outside the
+// store, such as the AOF, which it reaches through the client. It must only
+// be called from init functions, as the command table is read without a
+// lock.
+func RegisterCommand(name string, spec *commands.CommandSpec) {
+	commandTable[strings.ToUpper(name)] = spec
+}
+
+// LookupCommand returns the spec of the command called name, in upper case.
+func LookupCommand(name string) (*commands.CommandSpec, bool) {
+	spec, found := commandTable[name]
+	return spec, found
+}
+
+// HandleCommand runs cmd sent by client and writes its reply to w, counting
+// it in the stats of the client's server. Commands replayed from the AOF
+// have no client, and are applied directly without being logged again or
+// counted in the statistics.
+func HandleCommand(client Client, cmd *commands.Command, store *store.Store, log *log.Log, w *resp.Writer, fromLog bool) {
+	if fromLog {
+		replay(cmd, store, w)
+		return
+	}
+
+	stats := client.Stats()
+	errors := w.Errors()
+	defer stats.countErrors(w, errors)
 	spec, found := commandTable[cmd.Name]
+	// Like in Redis, monitors see commands before they run, so they also
+	// show those refused, such as unknown commands.
+	stats.feedMonitor(spec, client, cmd, time.Now())
 	if !found {
-		return fmt.Appendf(nil, "-ERR unknown command '%s'\r\n", cmd.Name)
+		w.Errorf("ERR unknown command '%s'", cmd.Name)
+		return
+	}
+	cs := stats.command(cmd.Name)
+	if !checkArity(spec, cmd) {
+		cs.rejected.Add(1)
+		commands.WrongArity(w, cmd)
+		return
+	}
+	stats.commandsProcessed.Add(1)
+
+	start := time.Now()
+	if !run(spec, client, cmd, store, log, w) {
+		cs.rejected.Add(1)
+		return
+	}
+	d := time.Since(start)
+	cs.record(d, w.Errors() > errors)
+	stats.slowlog.add(client, cmd, d)
+}
+
+// replay applies a command read from the AOF.
+func replay(cmd *commands.Command, store *store.Store, w *resp.Writer) {
+	spec, found := commandTable[cmd.Name]
+	if !found {
+		w.Errorf("ERR unknown command '%s'", cmd.Name)
+		return
+	}
+	if !checkArity(spec, cmd) {
+		commands.WrongArity(w, cmd)
+		return
 	}
+	spec.Handler(nil, cmd, store, w)
+}
 
-	if !fromLog && slices.Contains(spec.Flags, "write") {
-		go log.StoreWriteCommandToLog(cmd)
+// run runs a command for a client, logging it if it writes. It returns
+// false if the command was refused without running.
+func run(spec *commands.CommandSpec, client commands.Client, cmd *commands.Command, store *store.Store, log *log.Log, w *resp.Writer) bool {
+	if !slices.Contains(spec.Flags, "write") {
+		spec.Handler(client, cmd, store, w)
+		return true
+	}
+	if log == nil {
+		_, ran := execute(spec, client, cmd, store, w)
+		return ran
 	}
+	ran := true
+	log.Apply(func() []*commands.Command {
+		var cmds []*commands.Command
+		cmds, ran = execute(spec, client, cmd, store, w)
+		return cmds
+	})
+	return ran
+}
 
-	return spec.Handler(cmd, store)
+// checkArity reports whether cmd has the number of arguments spec allows.
+// Like in Redis the arity counts the command name, and -N means at least N.
+func checkArity(spec *commands.CommandSpec, cmd *commands.Command) bool {
+	n := len(cmd.Args) + 1
+	if spec.Arity < 0 {
+		return n >= -spec.Arity
+	}
+	return n == spec.Arity
+}
+
+// execute runs a write command and returns the commands to log for it,
+// preceded by the deletion of the expired keys it found. Keys are evicted
+// first if the store is over its memory limit, and deny-oom commands are
+// refused when that does not free enough, in which case the second value is
+// false.
+func execute(spec *commands.CommandSpec, client commands.Client, cmd *commands.Command, store *store.Store, w *resp.Writer) ([]*commands.Command, bool) {
+	evicted, err := store.Evict()
+	cmds := make([]*commands.Command, 0, len(evicted)+1)
+	for _, key := range evicted {
+		cmds = append(cmds, &commands.Command{Name: "DEL", Args: [][]byte{[]byte(key)}})
+	}
+	if err != nil && slices.Contains(spec.Flags, "deny-oom") {
+		w.Error(err.Error())
+		return cmds, false
+	}
+
+	errors := w.Errors()
+	spec.Handler(client, cmd, store, w)
+	for _, key := range store.TakeLazyExpired() {
+		cmds = append(cmds, &commands.Command{Name: "DEL", Args: [][]byte{[]byte(key)}})
+	}
+	if w.Errors() > errors {
+		// Error replies mean the store was left untouched, but for the
+		// expired keys it found.
+		return cmds, true
+	}
+	if spec.Propagate != nil {
+		return append(cmds, spec.Propagate(cmd, store)...), true
+	}
+	return append(cmds, cmd), true
 }
 
 // HandleCommand processes the COMMAND command, which introspects the server's command list.
-func handleCommand(cmd *commands.Command, store *store.Store) []byte {
+func handleCommand(client commands.Client, cmd *commands.Command, store *store.Store, w *resp.Writer) {
 	if len(cmd.Args) > 0 {
 		subcommand := strings.ToUpper(string(cmd.Args[0]))
 		if subcommand == "DOCS" {
 			if len(cmd.Args) < 2 {
-				return []byte("-ERR wrong number of arguments for 'COMMAND DOCS'\r\n")
+				w.Error("ERR wrong number of arguments for 'COMMAND DOCS'")
+				return
 			}
-			return BuildCommandDocs(cmd.Args[1:])
+			BuildCommandDocs(w, cmd.Args[1:])
+			return
 		}
-		return []byte("-ERR Unimplemented subcommand for 'COMMAND'\r\n")
+		w.Error("ERR Unimplemented subcommand for 'COMMAND'")
+		return
 	}
-	return BuildCommandInfo()
+	BuildCommandInfo(w)
 }
 
 var CommandHandlerSpec = &commands.CommandSpec{
@@ -98,47 +228,39 @@ var CommandHandlerSpec = &commands.CommandSpec{
 }
 
 // BuildCommandInfo dynamically creates the response for the COMMAND command.
-func BuildCommandInfo() []byte {
-	var b strings.Builder
-	b.WriteString(fmt.Sprintf("*%d\r\n", len(commandTable)))
-
+func BuildCommandInfo(w *resp.Writer) {
+	w.Array(len(commandTable))
 	for name, spec := range commandTable {
-		b.WriteString("*6\r\n") // 6 elements per command spec
-		b.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(name), strings.ToLower(name)))
-		b.WriteString(fmt.Sprintf(":%d\r\n", spec.Arity))
-		b.WriteString(fmt.Sprintf("*%d\r\n", len(spec.Flags)))
+		w.Array(6) // 6 elements per command spec
+		w.BulkString(strings.ToLower(name))
+		w.Int(int64(spec.Arity))
+		w.Set(len(spec.Flags))
 		for _, flag := range spec.Flags {
-			b.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(flag), flag))
+			w.Status(flag)
 		}
-		b.WriteString(fmt.Sprintf(":%d\r\n", spec.FirstKey))
-		b.WriteString(fmt.Sprintf(":%d\r\n", spec.LastKey))
-		b.WriteString(fmt.Sprintf(":%d\r\n", spec.KeyStep))
+		w.Int(int64(spec.FirstKey))
+		w.Int(int64(spec.LastKey))
+		w.Int(int64(spec.KeyStep))
 	}
-	return []byte(b.String())
 }
 
 // BuildCommandDocs dynamically creates the response for COMMAND DOCS.
-func BuildCommandDocs(commandNames [][]byte) []byte {
-	var b strings.Builder
-	b.WriteString(fmt.Sprintf("*%d\r\n", len(commandNames)*2))
-
+func BuildCommandDocs(w *resp.Writer, commandNames [][]byte) {
+	w.Map(len(commandNames))
 	for _, cmdNameBytes := range commandNames {
 		cmdName := strings.ToUpper(string(cmdNameBytes))
 		spec, ok := commandTable[cmdName]
 
-		b.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(cmdName), strings.ToLower(cmdName)))
+		w.BulkString(strings.ToLower(cmdName))
 
 		if !ok || spec.Documentation == nil {
-			b.WriteString("$-1\r\n")
+			w.Null()
 			continue
 		}
-		// ... (Implementation to build RESP map from spec.Documentation) ...
-		// This part can be complex, for now we can return a simple map
-		doc := spec.Documentation
-		b.WriteString(fmt.Sprintf("*%d\r\n", len(doc)*2))
-		summary, _ := doc["summary"].(string)
-		b.WriteString("$7\r\nsummary\r\n")
-		b.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(summary), summary))
-	}
-	return []byte(b.String())
+		// Only the summary is documented so far.
+		summary, _ := spec.Documentation["summary"].(string)
+		w.Map(1)
+		w.BulkString("summary")
+		w.BulkString(summary)
+	}
 }
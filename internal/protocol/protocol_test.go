@@ -0,0 +1,245 @@
+package protocol
+
+import (
+	"bufio"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teguhkurnia/redis-like/internal/log"
+	"github.com/teguhkurnia/redis-like/internal/protocol/commands"
+	"github.com/teguhkurnia/redis-like/internal/protocol/parser"
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+func command(name string, args ...string) *commands.Command {
+	cmd := &commands.Command{Name: name}
+	for _, arg := range args {
+		cmd.Args = append(cmd.Args, []byte(arg))
+	}
+	return cmd
+}
+
+// testClient is a connection that stays in RESP2, which is all these tests
+// need. All test clients share testStats.
+type testClient struct {
+	name string
+}
+
+var testStats = NewStats()
+
+func (c *testClient) ID() int64             { return 1 }
+func (c *testClient) Addr() string          { return "127.0.0.1:7000" }
+func (c *testClient) Name() string          { return c.name }
+func (c *testClient) SetName(name string)   { c.name = name }
+func (c *testClient) Protocol() int         { return resp.RESP2 }
+func (c *testClient) SetProtocol(proto int) {}
+func (c *testClient) Stats() *Stats         { return testStats }
+
+// handle runs cmd without an AOF and returns its RESP2 reply.
+func handle(cmd *commands.Command, s *store.Store, fromLog bool) string {
+	w := resp.NewWriter(resp.RESP2)
+	defer w.Release()
+	var client Client
+	if !fromLog {
+		client = &testClient{}
+	}
+	HandleCommand(client, cmd, s, nil, w, fromLog)
+	return string(w.Bytes())
+}
+
+func TestDenyOOM(t *testing.T) {
+	s := store.NewStore()
+	s.Set("key", "value")
+	s.SetMaxMemory(1, store.NoEviction)
+
+	result := handle(command("SET", "other", "value"), s, false)
+	assert.Equal(t, "-OOM command not allowed when used memory > 'maxmemory'.\r\n", result)
+	assert.False(t, s.Exists("other"))
+
+	// Commands that free memory or only read still run.
+	assert.Equal(t, "$5\r\nvalue\r\n", handle(command("GET", "key"), s, false))
+	assert.Equal(t, ":1\r\n", handle(command("DEL", "key"), s, false))
+
+	// Replaying the log is never refused.
+	s.SetMaxMemory(1, store.NoEviction)
+	assert.Equal(t, "+OK\r\n", handle(command("SET", "key", "value"), s, true))
+}
+
+func TestEvictsBeforeWrites(t *testing.T) {
+	s := store.NewStore()
+	s.Set("old", "value")
+	s.SetMaxMemory(s.Stats().UsedMemory, store.AllKeysLRU)
+
+	result := handle(command("SET", "new", "value"), s, false)
+	assert.Equal(t, "+OK\r\n", result)
+	result = handle(command("SET", "newer", "value"), s, false)
+	assert.Equal(t, "+OK\r\n", result)
+	assert.Equal(t, int64(1), s.Stats().EvictedKeys)
+	assert.True(t, s.Exists("newer"))
+}
+
+func TestLogsLazyExpiration(t *testing.T) {
+	dir := t.TempDir()
+	newLog := func() *log.Log {
+		return log.NewLog(filepath.Join(dir, "server.log"), filepath.Join(dir, "dump.rdb"), log.FsyncAlways, nil)
+	}
+	l := newLog()
+	s := store.NewStore()
+	w := resp.NewWriter(resp.RESP2)
+	defer w.Release()
+	for _, cmd := range []*commands.Command{
+		command("SET", "list", "a", "PX", "1"),
+		command("SET", "counter", "5", "PX", "1"),
+	} {
+		HandleCommand(&testClient{}, cmd, s, l, w, false)
+	}
+	time.Sleep(5 * time.Millisecond)
+	// The writes find the keys expired before the active cycle does.
+	HandleCommand(&testClient{}, command("RPUSH", "list", "x"), s, l, w, false)
+	HandleCommand(&testClient{}, command("INCR", "counter"), s, l, w, false)
+	assert.Equal(t, "+OK\r\n+OK\r\n:1\r\n:1\r\n", string(w.Bytes()))
+	require.NoError(t, l.Close())
+
+	l = newLog()
+	defer l.Close()
+	cmds, err := l.LoadCommandsFromLog()
+	require.NoError(t, err)
+	reloaded := store.NewStore()
+	reloaded.SetLoading(true)
+	for _, cmd := range cmds {
+		handle(cmd, reloaded, true)
+	}
+	reloaded.SetLoading(false)
+	list, err := reloaded.LRange("list", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x"}, list)
+	value, _, _ := reloaded.Get("counter")
+	assert.Equal(t, "1", value)
+}
+
+// typedStore returns a store holding one key of every type, named after it.
+func typedStore() *store.Store {
+	s := store.NewStore()
+	s.Set("string", "value")
+	s.RPush("list", []string{"a", "b"})
+	s.HSet("hash", "field", "value")
+	s.SAdd("set", []string{"member"})
+	s.ZAdd("zset", []store.SortedSet{{Score: 1, Member: "member"}})
+	return s
+}
+
+var keyTypes = []string{"string", "list", "hash", "set", "zset", "missing"}
+
+func TestNoCommandPanicsOnAnyKeyType(t *testing.T) {
+	argShapes := [][]string{
+		{},
+		{"1"},
+		{"-1"},
+		{"x"},
+		{"0", "-1"},
+		{"-100", "100"},
+		{"5", "2"},
+		{"field", "value"},
+		{"1", "member", "2", "other"},
+		{"0", "-1", "WITHSCORES"},
+		{"value", "EX", "10", "GET"},
+	}
+
+	for name := range commandTable {
+		for _, key := range keyTypes {
+			for _, shape := range argShapes {
+				args := append([]string{key}, shape...)
+				assert.NotPanics(t, func() {
+					result := handle(command(name, args...), typedStore(), false)
+					// Every reply is exactly one well-formed RESP value.
+					reader := bufio.NewReader(strings.NewReader(result))
+					_, err := parser.ParseNextValue(reader)
+					assert.NoError(t, err, "%s %v: %q", name, args, result)
+					assert.Zero(t, reader.Buffered(), "%s %v: %q", name, args, result)
+				}, "%s %v", name, args)
+			}
+		}
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	tests := []struct {
+		keyType string
+		args    []string
+	}{
+		{"string", []string{"GET"}},
+		{"string", []string{"INCR"}},
+		{"string", []string{"DECR"}},
+		{"string", []string{"SET", "v", "GET"}},
+		{"list", []string{"LPUSH", "a"}},
+		{"list", []string{"RPUSH", "a"}},
+		{"list", []string{"LRANGE", "0", "-1"}},
+		{"list", []string{"LPOP"}},
+		{"list", []string{"RPOP"}},
+		{"list", []string{"LLEN"}},
+		{"hash", []string{"HSET", "field", "value"}},
+		{"hash", []string{"HGET", "field"}},
+		{"hash", []string{"HGETALL"}},
+		{"hash", []string{"HDEL", "field"}},
+		{"set", []string{"SADD", "member"}},
+		{"set", []string{"SREM", "member"}},
+		{"set", []string{"SMEMBERS"}},
+		{"set", []string{"SISMEMBER", "member"}},
+		{"zset", []string{"ZADD", "1", "member"}},
+		{"zset", []string{"ZRANGE", "0", "-1"}},
+		{"zset", []string{"ZREM", "member"}},
+	}
+
+	for _, tt := range tests {
+		for _, key := range keyTypes {
+			args := append([]string{key}, tt.args[1:]...)
+			result := handle(command(tt.args[0], args...), typedStore(), false)
+			if key == tt.keyType || key == "missing" {
+				assert.NotContains(t, result, "WRONGTYPE", "%s %v", tt.args[0], args)
+			} else {
+				assert.Equal(t, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", result, "%s %v", tt.args[0], args)
+			}
+		}
+	}
+}
+
+func TestArity(t *testing.T) {
+	for name, spec := range commandTable {
+		minArgs := spec.Arity - 1
+		if spec.Arity < 0 {
+			minArgs = -spec.Arity - 1
+		}
+		for n := 0; n <= minArgs+3; n++ {
+			args := make([]string, n)
+			for i := range args {
+				args[i] = "1"
+			}
+			result := handle(command(name, args...), store.NewStore(), false)
+			wrongArity := "-ERR wrong number of arguments for '" + strings.ToLower(name) + "' command\r\n"
+			if n < minArgs || (spec.Arity > 0 && n > minArgs) {
+				assert.Equal(t, wrongArity, result, "%s with %d args", name, n)
+			} else if spec.Arity < 0 || n == minArgs {
+				// Handlers may still refuse some counts, like an odd
+				// number of field-value arguments, but never panic.
+				assert.NotEmpty(t, result, "%s with %d args", name, n)
+			}
+		}
+	}
+}
+
+func TestOptionalArguments(t *testing.T) {
+	s := store.NewStore()
+	s.RPush("list", []string{"a", "b", "c"})
+	assert.Equal(t, "*1\r\n$1\r\nc\r\n", handle(command("RPOP", "list", "1"), s, false))
+	assert.Equal(t, "-ERR wrong number of arguments for 'rpop' command\r\n", handle(command("RPOP", "list", "1", "1"), s, false))
+
+	s.ZAdd("zset", []store.SortedSet{{Score: 1, Member: "one"}})
+	assert.Equal(t, "*2\r\n$3\r\none\r\n$1\r\n1\r\n", handle(command("ZRANGE", "zset", "0", "-1", "withscores"), s, false))
+	assert.Equal(t, "-ERR syntax error\r\n", handle(command("ZRANGE", "zset", "0", "-1", "BYLEX"), s, false))
+	assert.Equal(t, "-ERR wrong number of arguments for 'zadd' command\r\n", handle(command("ZADD", "zset", "1", "one", "2"), s, false))
+}
@@ -0,0 +1,172 @@
+// Package resp encodes replies in the Redis Serialization Protocol. Every
+// function appends to a buffer in the given protocol version: RESP3 types
+// that RESP2 lacks are downgraded to their RESP2 equivalent, the way Redis
+// replies to clients that have not sent HELLO 3.
+package resp
+
+import (
+	"math"
+	"strconv"
+)
+
+// Protocol versions negotiated with HELLO.
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+func appendHeader(buf []byte, prefix byte, n int) []byte {
+	buf = append(buf, prefix)
+	buf = strconv.AppendInt(buf, int64(n), 10)
+	return append(buf, '\r', '\n')
+}
+
+// appendLine appends a status or error line. Like in Redis, CR and LF in s
+// become spaces, as they would end the line early and desynchronize the
+// client when s echoes its input.
+func appendLine(buf []byte, prefix byte, s string) []byte {
+	buf = append(buf, prefix)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\r' || c == '\n' {
+			c = ' '
+		}
+		buf = append(buf, c)
+	}
+	return append(buf, '\r', '\n')
+}
+
+func AppendStatus(buf []byte, s string) []byte {
+	return appendLine(buf, '+', s)
+}
+
+// AppendError appends an error reply. msg should start with an error code
+// such as ERR or WRONGTYPE.
+func AppendError(buf []byte, msg string) []byte {
+	return appendLine(buf, '-', msg)
+}
+
+func AppendInt(buf []byte, n int64) []byte {
+	buf = append(buf, ':')
+	buf = strconv.AppendInt(buf, n, 10)
+	return append(buf, '\r', '\n')
+}
+
+func AppendBulk(buf []byte, b []byte) []byte {
+	buf = appendHeader(buf, '$', len(b))
+	buf = append(buf, b...)
+	return append(buf, '\r', '\n')
+}
+
+func AppendBulkString(buf []byte, s string) []byte {
+	buf = appendHeader(buf, '$', len(s))
+	buf = append(buf, s...)
+	return append(buf, '\r', '\n')
+}
+
+// AppendNull appends a null, which RESP2 spells as a null bulk string.
+func AppendNull(buf []byte, proto int) []byte {
+	if proto >= RESP3 {
+		return append(buf, '_', '\r', '\n')
+	}
+	return append(buf, "$-1\r\n"...)
+}
+
+// AppendNullArray appends a null, which RESP2 spells as a null array.
+func AppendNullArray(buf []byte, proto int) []byte {
+	if proto >= RESP3 {
+		return append(buf, '_', '\r', '\n')
+	}
+	return append(buf, "*-1\r\n"...)
+}
+
+// AppendArrayLen starts an array of n elements, which must follow.
+func AppendArrayLen(buf []byte, n int) []byte {
+	return appendHeader(buf, '*', n)
+}
+
+// AppendMapLen starts a map of n key-value pairs, which must follow as 2n
+// elements. RESP2 gets a flat array of keys and values.
+func AppendMapLen(buf []byte, proto int, n int) []byte {
+	if proto >= RESP3 {
+		return appendHeader(buf, '%', n)
+	}
+	return appendHeader(buf, '*', 2*n)
+}
+
+// AppendSetLen starts a set of n elements, which RESP2 gets as an array.
+func AppendSetLen(buf []byte, proto int, n int) []byte {
+	if proto >= RESP3 {
+		return appendHeader(buf, '~', n)
+	}
+	return appendHeader(buf, '*', n)
+}
+
+// AppendPushLen starts an out-of-band push message of n elements, which
+// RESP2 gets as an array.
+func AppendPushLen(buf []byte, proto int, n int) []byte {
+	if proto >= RESP3 {
+		return appendHeader(buf, '>', n)
+	}
+	return appendHeader(buf, '*', n)
+}
+
+// AppendDouble appends a floating point number, which RESP2 gets as a bulk
+// string formatted the same way.
+func AppendDouble(buf []byte, proto int, f float64) []byte {
+	var s string
+	switch {
+	case math.IsInf(f, 1):
+		s = "inf"
+	case math.IsInf(f, -1):
+		s = "-inf"
+	case math.IsNaN(f):
+		s = "nan"
+	default:
+		s = strconv.FormatFloat(f, 'g', 17, 64)
+	}
+	if proto >= RESP3 {
+		buf = append(buf, ',')
+		buf = append(buf, s...)
+		return append(buf, '\r', '\n')
+	}
+	return AppendBulkString(buf, s)
+}
+
+// AppendBool appends a boolean, which RESP2 gets as the integer 1 or 0.
+func AppendBool(buf []byte, proto int, b bool) []byte {
+	if proto >= RESP3 {
+		if b {
+			return append(buf, "#t\r\n"...)
+		}
+		return append(buf, "#f\r\n"...)
+	}
+	if b {
+		return AppendInt(buf, 1)
+	}
+	return AppendInt(buf, 0)
+}
+
+// AppendBigNumber appends an integer of arbitrary size given in decimal,
+// which RESP2 gets as a bulk string.
+func AppendBigNumber(buf []byte, proto int, digits string) []byte {
+	if proto >= RESP3 {
+		buf = append(buf, '(')
+		buf = append(buf, digits...)
+		return append(buf, '\r', '\n')
+	}
+	return AppendBulkString(buf, digits)
+}
+
+// AppendVerbatim appends text meant to be shown as is, such as INFO, with a
+// three letter format like "txt". RESP2 gets the text as a bulk string.
+func AppendVerbatim(buf []byte, proto int, format string, text string) []byte {
+	if proto >= RESP3 {
+		buf = appendHeader(buf, '=', len(format)+1+len(text))
+		buf = append(buf, format...)
+		buf = append(buf, ':')
+		buf = append(buf, text...)
+		return append(buf, '\r', '\n')
+	}
+	return AppendBulkString(buf, text)
+}
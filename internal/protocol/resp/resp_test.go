@@ -0,0 +1,55 @@
+package resp
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDowngradeToRESP2(t *testing.T) {
+	cases := []struct {
+		encode func(buf []byte, proto int) []byte
+		resp2  string
+		resp3  string
+	}{
+		{func(b []byte, p int) []byte { return AppendNull(b, p) }, "$-1\r\n", "_\r\n"},
+		{func(b []byte, p int) []byte { return AppendNullArray(b, p) }, "*-1\r\n", "_\r\n"},
+		{func(b []byte, p int) []byte { return AppendMapLen(b, p, 2) }, "*4\r\n", "%2\r\n"},
+		{func(b []byte, p int) []byte { return AppendSetLen(b, p, 2) }, "*2\r\n", "~2\r\n"},
+		{func(b []byte, p int) []byte { return AppendPushLen(b, p, 2) }, "*2\r\n", ">2\r\n"},
+		{func(b []byte, p int) []byte { return AppendDouble(b, p, 0.1) }, "$19\r\n0.10000000000000001\r\n", ",0.10000000000000001\r\n"},
+		{func(b []byte, p int) []byte { return AppendDouble(b, p, math.Inf(-1)) }, "$4\r\n-inf\r\n", ",-inf\r\n"},
+		{func(b []byte, p int) []byte { return AppendBool(b, p, true) }, ":1\r\n", "#t\r\n"},
+		{func(b []byte, p int) []byte { return AppendBigNumber(b, p, "123") }, "$3\r\n123\r\n", "(123\r\n"},
+		{func(b []byte, p int) []byte { return AppendVerbatim(b, p, "txt", "hi") }, "$2\r\nhi\r\n", "=6\r\ntxt:hi\r\n"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.resp2, string(c.encode(nil, RESP2)))
+		assert.Equal(t, c.resp3, string(c.encode(nil, RESP3)))
+	}
+}
+
+func TestWriter(t *testing.T) {
+	w := NewWriter(RESP3)
+	defer w.Release()
+	w.Map(1)
+	w.BulkString("key")
+	w.Array(3)
+	w.Int(1)
+	w.Null()
+	w.Error("ERR failed")
+	assert.Equal(t, "%1\r\n$3\r\nkey\r\n*3\r\n:1\r\n_\r\n-ERR failed\r\n", string(w.Bytes()))
+	assert.Equal(t, 1, w.Errors())
+	assert.Equal(t, "ERR failed", w.LastError())
+
+	w.Reset()
+	w.SetProtocol(RESP2)
+	w.Map(1)
+	assert.Equal(t, "*2\r\n", string(w.Bytes()))
+}
+
+func TestLinesEscapeCRLF(t *testing.T) {
+	assert.Equal(t, "-ERR unknown command 'FOO  BAR'\r\n", string(AppendError(nil, "ERR unknown command 'FOO\r\nBAR'")))
+	assert.Equal(t, "+a b\r\n", string(AppendStatus(nil, "a\nb")))
+}
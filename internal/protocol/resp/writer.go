@@ -0,0 +1,150 @@
+package resp
+
+import (
+	"fmt"
+	"sync"
+)
+
+// maxPooledBuffer is the largest buffer returned to the pool, so one huge
+// reply does not pin its memory for the life of the process.
+const maxPooledBuffer = 64 * 1024
+
+var writerPool = sync.Pool{
+	New: func() any {
+		return &Writer{buf: make([]byte, 0, 512)}
+	},
+}
+
+// Writer builds replies for one connection in the protocol version it
+// negotiated. Handlers call one method per value; aggregates are started
+// with their length and followed by that many values. The bytes accumulate
+// until the caller takes them with Bytes and calls Reset.
+type Writer struct {
+	buf       []byte
+	proto     int
+	errors    int
+	lastError string
+}
+
+// NewWriter returns a pooled Writer encoding in proto, which is given back
+// with Release once the connection is done with it.
+func NewWriter(proto int) *Writer {
+	w := writerPool.Get().(*Writer)
+	w.proto = proto
+	return w
+}
+
+// Release resets w and returns it to the pool. w must not be used after.
+func (w *Writer) Release() {
+	if cap(w.buf) > maxPooledBuffer {
+		w.buf = make([]byte, 0, 512)
+	}
+	w.Reset()
+	w.errors = 0
+	w.lastError = ""
+	writerPool.Put(w)
+}
+
+// Protocol returns the RESP version w encodes in.
+func (w *Writer) Protocol() int {
+	return w.proto
+}
+
+// SetProtocol switches the encoding of the replies that follow, as HELLO
+// does for the rest of the connection.
+func (w *Writer) SetProtocol(proto int) {
+	w.proto = proto
+}
+
+// Bytes returns the replies written since the last Reset. They are only
+// valid until the next call that writes to w.
+func (w *Writer) Bytes() []byte {
+	return w.buf
+}
+
+func (w *Writer) Reset() {
+	w.buf = w.buf[:0]
+}
+
+// Errors returns how many error replies w has written, so callers can tell
+// whether a handler failed.
+func (w *Writer) Errors() int {
+	return w.errors
+}
+
+// LastError returns the message of the last error reply w has written.
+func (w *Writer) LastError() string {
+	return w.lastError
+}
+
+func (w *Writer) Status(s string) {
+	w.buf = AppendStatus(w.buf, s)
+}
+
+// Error writes an error reply. msg should start with an error code such as
+// ERR or WRONGTYPE.
+func (w *Writer) Error(msg string) {
+	w.errors++
+	w.lastError = msg
+	w.buf = AppendError(w.buf, msg)
+}
+
+func (w *Writer) Errorf(format string, args ...any) {
+	w.Error(fmt.Sprintf(format, args...))
+}
+
+func (w *Writer) Int(n int64) {
+	w.buf = AppendInt(w.buf, n)
+}
+
+func (w *Writer) Bulk(b []byte) {
+	w.buf = AppendBulk(w.buf, b)
+}
+
+func (w *Writer) BulkString(s string) {
+	w.buf = AppendBulkString(w.buf, s)
+}
+
+// Null writes a missing value, such as GET on a missing key.
+func (w *Writer) Null() {
+	w.buf = AppendNull(w.buf, w.proto)
+}
+
+// NullArray writes a missing aggregate, such as LPOP with a count on a
+// missing key.
+func (w *Writer) NullArray() {
+	w.buf = AppendNullArray(w.buf, w.proto)
+}
+
+func (w *Writer) Array(n int) {
+	w.buf = AppendArrayLen(w.buf, n)
+}
+
+// Map starts a map of n pairs: 2n values, keys and values in turn.
+func (w *Writer) Map(n int) {
+	w.buf = AppendMapLen(w.buf, w.proto, n)
+}
+
+func (w *Writer) Set(n int) {
+	w.buf = AppendSetLen(w.buf, w.proto, n)
+}
+
+func (w *Writer) Push(n int) {
+	w.buf = AppendPushLen(w.buf, w.proto, n)
+}
+
+func (w *Writer) Double(f float64) {
+	w.buf = AppendDouble(w.buf, w.proto, f)
+}
+
+func (w *Writer) Bool(b bool) {
+	w.buf = AppendBool(w.buf, w.proto, b)
+}
+
+func (w *Writer) BigNumber(digits string) {
+	w.buf = AppendBigNumber(w.buf, w.proto, digits)
+}
+
+func (w *Writer) Verbatim(format string, text string) {
+	w.buf = AppendVerbatim(w.buf, w.proto, format, text)
+}
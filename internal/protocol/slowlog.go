@@ -0,0 +1,235 @@
+package protocol
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/teguhkurnia/redis-like/internal/protocol/commands"
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+const (
+	// Like in Redis, slow log entries keep at most slowlogMaxArgs arguments
+	// of at most slowlogMaxArgLen bytes each.
+	slowlogMaxArgs   = 32
+	slowlogMaxArgLen = 128
+)
+
+// slowlogEntry is a command that ran for longer than the slow log
+// threshold.
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	args     []string
+	addr     string
+	name     string
+}
+
+// slowlog keeps the last commands that ran for at least threshold
+// microseconds in a ring buffer of at most maxLen entries.
+type slowlog struct {
+	threshold atomic.Int64
+
+	mu      sync.Mutex
+	entries []slowlogEntry
+	// oldest is the index of the oldest entry once entries is full.
+	oldest int
+	maxLen int
+	nextID int64
+}
+
+func newSlowlog(threshold int64, maxLen int) *slowlog {
+	l := &slowlog{}
+	l.configure(threshold, maxLen)
+	return l
+}
+
+// SetSlowlog sets the slow log threshold in microseconds and how many
+// entries it keeps. A negative threshold disables the slow log.
+func (s *Stats) SetSlowlog(threshold int64, maxLen int) {
+	s.slowlog.configure(threshold, maxLen)
+}
+
+func (l *slowlog) configure(threshold int64, maxLen int) {
+	l.threshold.Store(threshold)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if maxLen == l.maxLen {
+		return
+	}
+	entries := l.newest(maxLen)
+	// Keep the ring ordered oldest first.
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	l.entries = entries
+	l.oldest = 0
+	l.maxLen = maxLen
+}
+
+// add records cmd if it ran for at least the threshold.
+func (l *slowlog) add(client commands.Client, cmd *commands.Command, d time.Duration) {
+	threshold := l.threshold.Load()
+	if threshold < 0 || d.Microseconds() < threshold {
+		return
+	}
+	entry := slowlogEntry{
+		time:     time.Now().Add(-d),
+		duration: d,
+		args:     slowlogArgs(cmd),
+	}
+	if client != nil {
+		entry.addr = client.Addr()
+		entry.name = client.Name()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry.id = l.nextID
+	l.nextID++
+	switch {
+	case l.maxLen == 0:
+	case len(l.entries) < l.maxLen:
+		l.entries = append(l.entries, entry)
+	default:
+		l.entries[l.oldest] = entry
+		l.oldest = (l.oldest + 1) % l.maxLen
+	}
+}
+
+// newest returns up to count entries, newest first. The caller holds mu.
+func (l *slowlog) newest(count int) []slowlogEntry {
+	count = min(count, len(l.entries))
+	result := make([]slowlogEntry, count)
+	for i := range result {
+		// The newest entry is just before the oldest one.
+		j := (l.oldest - 1 - i + 2*len(l.entries)) % len(l.entries)
+		result[i] = l.entries[j]
+	}
+	return result
+}
+
+func (l *slowlog) get(count int) []slowlogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.newest(count)
+}
+
+func (l *slowlog) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+func (l *slowlog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = l.entries[:0]
+	l.oldest = 0
+}
+
+// slowlogArgs returns the command name and arguments as logged, truncating
+// long commands and arguments.
+func slowlogArgs(cmd *commands.Command) []string {
+	n := min(len(cmd.Args)+1, slowlogMaxArgs)
+	args := make([]string, 0, n)
+	args = append(args, cmd.Name)
+	for i, arg := range cmd.Args {
+		if len(args) == slowlogMaxArgs-1 && i < len(cmd.Args)-1 {
+			args = append(args, fmt.Sprintf("... (%d more arguments)", len(cmd.Args)-i))
+			break
+		}
+		if len(arg) > slowlogMaxArgLen {
+			args = append(args, fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgLen], len(arg)-slowlogMaxArgLen))
+			continue
+		}
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+var slowlogHelp = []string{
+	"SLOWLOG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"GET [<count>]",
+	"    Return top <count> entries from the slowlog (default: 10, -1 mean all).",
+	"    Entries are made of:",
+	"    id, timestamp, time in microseconds, arguments array, client IP and port,",
+	"    client name",
+	"LEN",
+	"    Return the length of the slowlog.",
+	"RESET",
+	"    Reset the slowlog.",
+	"HELP",
+	"    Print this help.",
+}
+
+// handleSlowlog implements SLOWLOG GET, LEN, RESET and HELP.
+func handleSlowlog(client commands.Client, cmd *commands.Command, store *store.Store, w *resp.Writer) {
+	slowLog := client.(Client).Stats().slowlog
+	sub := strings.ToUpper(string(cmd.Args[0]))
+	switch {
+	case sub == "GET" && len(cmd.Args) <= 2:
+		count := 10
+		if len(cmd.Args) == 2 {
+			n, err := strconv.ParseInt(string(cmd.Args[1]), 10, 64)
+			if err != nil || n < -1 {
+				w.Error("ERR count should be greater than or equal to -1")
+				return
+			}
+			count = int(n)
+			if n == -1 {
+				count = math.MaxInt
+			}
+		}
+		writeSlowlog(w, slowLog.get(count))
+	case sub == "LEN" && len(cmd.Args) == 1:
+		w.Int(int64(slowLog.len()))
+	case sub == "RESET" && len(cmd.Args) == 1:
+		slowLog.reset()
+		w.Status("OK")
+	case sub == "HELP" && len(cmd.Args) == 1:
+		w.Array(len(slowlogHelp))
+		for _, line := range slowlogHelp {
+			w.Status(line)
+		}
+	case sub == "GET" || sub == "LEN" || sub == "RESET" || sub == "HELP":
+		w.Errorf("ERR wrong number of arguments for 'slowlog|%s' command", strings.ToLower(sub))
+	default:
+		w.Errorf("ERR unknown subcommand '%s'. Try SLOWLOG HELP.", cmd.Args[0])
+	}
+}
+
+func writeSlowlog(w *resp.Writer, entries []slowlogEntry) {
+	w.Array(len(entries))
+	for _, entry := range entries {
+		w.Array(6)
+		w.Int(entry.id)
+		w.Int(entry.time.Unix())
+		w.Int(entry.duration.Microseconds())
+		w.Array(len(entry.args))
+		for _, arg := range entry.args {
+			w.BulkString(arg)
+		}
+		w.BulkString(entry.addr)
+		w.BulkString(entry.name)
+	}
+}
+
+var SlowlogSpec = &commands.CommandSpec{
+	Handler:  handleSlowlog,
+	Arity:    -2,
+	Flags:    []string{"admin", "loading", "stale"},
+	FirstKey: 0,
+	LastKey:  0,
+	KeyStep:  0,
+	Documentation: map[string]any{
+		"summary": "Manages the slow log of commands that took long to run.",
+	},
+}
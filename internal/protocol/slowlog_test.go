@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+func TestSlowlogRing(t *testing.T) {
+	l := newSlowlog(0, 3)
+	for i := 0; i < 5; i++ {
+		l.add(&testClient{name: "worker"}, command("GET", fmt.Sprint(i)), time.Millisecond)
+	}
+	entries := l.get(10)
+	require.Len(t, entries, 3)
+	for i, entry := range entries {
+		assert.Equal(t, int64(4-i), entry.id)
+		assert.Equal(t, []string{"GET", fmt.Sprint(4 - i)}, entry.args)
+		assert.Equal(t, "127.0.0.1:7000", entry.addr)
+		assert.Equal(t, "worker", entry.name)
+		assert.Equal(t, time.Millisecond, entry.duration)
+	}
+	assert.Len(t, l.get(1), 1)
+
+	// Shrinking keeps the newest entries, growing keeps them all.
+	l.configure(0, 2)
+	assert.Equal(t, []int64{4, 3}, ids(l.get(10)))
+	l.configure(0, 4)
+	l.add(nil, command("GET", "5"), time.Millisecond)
+	assert.Equal(t, []int64{5, 4, 3}, ids(l.get(10)))
+
+	// Faster commands are left out, and a negative threshold disables it.
+	l.configure(1000, 4)
+	l.add(nil, command("GET", "6"), 999*time.Microsecond)
+	l.configure(-1, 4)
+	l.add(nil, command("GET", "7"), time.Hour)
+	assert.Equal(t, 3, l.len())
+
+	l.reset()
+	assert.Zero(t, l.len())
+	l.configure(0, 0)
+	l.add(nil, command("GET", "8"), time.Millisecond)
+	assert.Empty(t, l.get(10))
+}
+
+func ids(entries []slowlogEntry) []int64 {
+	result := make([]int64, len(entries))
+	for i, entry := range entries {
+		result[i] = entry.id
+	}
+	return result
+}
+
+func TestSlowlogArgs(t *testing.T) {
+	args := make([]string, 40)
+	for i := range args {
+		args[i] = fmt.Sprint(i)
+	}
+	logged := slowlogArgs(command("RPUSH", args...))
+	require.Len(t, logged, 32)
+	assert.Equal(t, "RPUSH", logged[0])
+	assert.Equal(t, "29", logged[30])
+	assert.Equal(t, "... (10 more arguments)", logged[31])
+
+	assert.Len(t, slowlogArgs(command("RPUSH", args[:31]...)), 32)
+	long := slowlogArgs(command("SET", "key", strings.Repeat("x", 200)))
+	assert.Equal(t, strings.Repeat("x", 128)+"... (72 more bytes)", long[2])
+}
+
+func TestSlowlogCommand(t *testing.T) {
+	testStats.SetSlowlog(0, 128)
+	defer testStats.SetSlowlog(10000, 128)
+	s := store.NewStore()
+	handle(command("SLOWLOG", "RESET"), s, false)
+	handle(command("SET", "key", "value"), s, false)
+
+	// Every command is slow enough, including SLOWLOG RESET itself.
+	assert.Equal(t, ":2\r\n", handle(command("SLOWLOG", "LEN"), s, false))
+	reply := handle(command("SLOWLOG", "GET", "2"), s, false)
+	assert.True(t, strings.HasPrefix(reply, "*2\r\n*6\r\n:"), reply)
+	assert.Contains(t, reply, "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n$14\r\n127.0.0.1:7000\r\n$0\r\n\r\n")
+
+	assert.Equal(t, "-ERR count should be greater than or equal to -1\r\n", handle(command("SLOWLOG", "GET", "-2"), s, false))
+	assert.Equal(t, "-ERR wrong number of arguments for 'slowlog|len' command\r\n", handle(command("SLOWLOG", "LEN", "1"), s, false))
+	assert.Equal(t, "-ERR unknown subcommand 'nope'. Try SLOWLOG HELP.\r\n", handle(command("SLOWLOG", "nope"), s, false))
+}
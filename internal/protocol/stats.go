@@ -0,0 +1,271 @@
+package protocol
+
+import (
+	"math/bits"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/teguhkurnia/redis-like/internal/protocol/commands"
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+// histogramBuckets is the number of latency buckets. Bucket i counts calls
+// that took less than 2^i microseconds, and the last one everything slower.
+const histogramBuckets = 40
+
+// maxErrorCodes caps the error codes tracked by errorstats, so clients
+// cannot grow the table without bound.
+const maxErrorCodes = 128
+
+// commandStats counts the calls of one command, for INFO commandstats and
+// LATENCY HISTOGRAM.
+type commandStats struct {
+	calls     atomic.Int64
+	usec      atomic.Int64
+	rejected  atomic.Int64
+	failed    atomic.Int64
+	histogram [histogramBuckets]atomic.Int64
+}
+
+// Stats records the commands a server runs for its clients, for INFO,
+// LATENCY, SLOWLOG and CONFIG RESETSTAT. Every server keeps its own, which
+// HandleCommand reaches through the client.
+type Stats struct {
+	// commandsProcessed counts the commands run for clients.
+	commandsProcessed atomic.Int64
+
+	// commands holds a *commandStats per command name, added the first
+	// time the command is called.
+	commands sync.Map
+
+	errorsMu     sync.Mutex
+	errors       map[string]int64
+	errorReplies atomic.Int64
+
+	// slowlog is left alone by Reset, like in Redis.
+	slowlog *slowlog
+	// monitor is fed the commands as they are counted, see SetMonitor.
+	monitor atomic.Pointer[MonitorFunc]
+}
+
+func NewStats() *Stats {
+	return &Stats{errors: make(map[string]int64), slowlog: newSlowlog(10000, 128)}
+}
+
+// Client is a client HandleCommand runs commands for, connected to a server
+// that keeps Stats.
+type Client interface {
+	commands.Client
+	Stats() *Stats
+}
+
+// CommandsProcessed returns how many commands were run for clients since
+// the start or the last Reset.
+func (s *Stats) CommandsProcessed() int64 {
+	return s.commandsProcessed.Load()
+}
+
+// Reset zeroes the command statistics, as CONFIG RESETSTAT does.
+func (s *Stats) Reset() {
+	s.commandsProcessed.Store(0)
+	s.commands.Clear()
+	s.errorReplies.Store(0)
+	s.errorsMu.Lock()
+	clear(s.errors)
+	s.errorsMu.Unlock()
+}
+
+func (s *Stats) command(name string) *commandStats {
+	if stats, found := s.commands.Load(name); found {
+		return stats.(*commandStats)
+	}
+	stats, _ := s.commands.LoadOrStore(name, &commandStats{})
+	return stats.(*commandStats)
+}
+
+// record counts a call that ran for d.
+func (s *commandStats) record(d time.Duration, failed bool) {
+	usec := d.Microseconds()
+	s.calls.Add(1)
+	s.usec.Add(usec)
+	if failed {
+		s.failed.Add(1)
+	}
+	bucket := min(bits.Len64(uint64(usec)), histogramBuckets-1)
+	s.histogram[bucket].Add(1)
+}
+
+// countErrors counts the error replies written to w since it held errors.
+func (s *Stats) countErrors(w *resp.Writer, errors int) {
+	n := w.Errors() - errors
+	if n <= 0 {
+		return
+	}
+	s.errorReplies.Add(int64(n))
+
+	// Like Redis, the code is the first word of the message.
+	code, _, _ := strings.Cut(w.LastError(), " ")
+	s.errorsMu.Lock()
+	defer s.errorsMu.Unlock()
+	if _, found := s.errors[code]; found || len(s.errors) < maxErrorCodes {
+		s.errors[code] += int64(n)
+	}
+}
+
+// CommandStats describes the calls of one command.
+type CommandStats struct {
+	// Name is the command name in lower case.
+	Name string
+	// Calls counts the calls that ran, of which FailedCalls replied with
+	// an error. RejectedCalls were refused before running, e.g. for a
+	// wrong number of arguments.
+	Calls         int64
+	Usec          int64
+	RejectedCalls int64
+	FailedCalls   int64
+	// Histogram counts calls by latency: Histogram[i] took less than 2^i
+	// microseconds.
+	Histogram []int64
+}
+
+// Percentile returns the latency under which the fraction p of the calls
+// ran, rounded up to a bucket boundary.
+func (s CommandStats) Percentile(p float64) time.Duration {
+	var total int64
+	for _, n := range s.Histogram {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	var seen int64
+	for i, n := range s.Histogram {
+		seen += n
+		if float64(seen) >= p*float64(total) {
+			return time.Duration(1<<i) * time.Microsecond
+		}
+	}
+	return time.Duration(1<<(len(s.Histogram)-1)) * time.Microsecond
+}
+
+// AllCommandStats returns the stats of the commands called since the start
+// or the last Reset, sorted by name.
+func (s *Stats) AllCommandStats() []CommandStats {
+	var all []CommandStats
+	s.commands.Range(func(name, value any) bool {
+		stats := value.(*commandStats)
+		cs := CommandStats{
+			Name:          strings.ToLower(name.(string)),
+			Calls:         stats.calls.Load(),
+			Usec:          stats.usec.Load(),
+			RejectedCalls: stats.rejected.Load(),
+			FailedCalls:   stats.failed.Load(),
+			Histogram:     make([]int64, histogramBuckets),
+		}
+		for i := range stats.histogram {
+			cs.Histogram[i] = stats.histogram[i].Load()
+		}
+		all = append(all, cs)
+		return true
+	})
+	slices.SortFunc(all, func(a, b CommandStats) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return all
+}
+
+// ErrorStats returns the number of error replies by error code, such as
+// ERR or WRONGTYPE.
+func (s *Stats) ErrorStats() map[string]int64 {
+	s.errorsMu.Lock()
+	defer s.errorsMu.Unlock()
+	result := make(map[string]int64, len(s.errors))
+	for code, n := range s.errors {
+		result[code] = n
+	}
+	return result
+}
+
+// ErrorReplies returns the number of error replies sent to clients.
+func (s *Stats) ErrorReplies() int64 {
+	return s.errorReplies.Load()
+}
+
+var latencyHelp = []string{
+	"LATENCY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"HISTOGRAM [COMMAND ...]",
+	"    Return a cumulative distribution of latencies in the format of a histogram for the specified command names.",
+	"    If no commands are specified then all histograms are replied.",
+	"HELP",
+	"    Print this help.",
+}
+
+// handleLatency implements LATENCY HISTOGRAM and LATENCY HELP.
+func handleLatency(client commands.Client, cmd *commands.Command, store *store.Store, w *resp.Writer) {
+	switch sub := strings.ToUpper(string(cmd.Args[0])); sub {
+	case "HISTOGRAM":
+		latencyHistogram(w, client.(Client).Stats(), cmd.Args[1:])
+	case "HELP":
+		w.Array(len(latencyHelp))
+		for _, line := range latencyHelp {
+			w.Status(line)
+		}
+	default:
+		w.Errorf("ERR unknown subcommand '%s'. Try LATENCY HELP.", cmd.Args[0])
+	}
+}
+
+// latencyHistogram replies with a map from command name to its calls and
+// the cumulative count of calls by latency bucket, leaving out commands
+// that were not called.
+func latencyHistogram(w *resp.Writer, stats *Stats, names [][]byte) {
+	all := stats.AllCommandStats()
+	if len(names) > 0 {
+		wanted := make(map[string]bool, len(names))
+		for _, name := range names {
+			wanted[strings.ToLower(string(name))] = true
+		}
+		all = slices.DeleteFunc(all, func(cs CommandStats) bool { return !wanted[cs.Name] })
+	}
+	all = slices.DeleteFunc(all, func(cs CommandStats) bool { return cs.Calls == 0 })
+
+	w.Map(len(all))
+	for _, cs := range all {
+		w.BulkString(cs.Name)
+		w.Map(2)
+		w.BulkString("calls")
+		w.Int(cs.Calls)
+		w.BulkString("histogram_usec")
+
+		// Buckets are listed up to the slowest call.
+		last := 0
+		for i, n := range cs.Histogram {
+			if n > 0 {
+				last = i
+			}
+		}
+		w.Map(last + 1)
+		var cumulative int64
+		for i := 0; i <= last; i++ {
+			cumulative += cs.Histogram[i]
+			w.Int(1 << i)
+			w.Int(cumulative)
+		}
+	}
+}
+
+var LatencySpec = &commands.CommandSpec{
+	Handler:  handleLatency,
+	Arity:    -2,
+	Flags:    []string{"admin", "loading", "stale"},
+	FirstKey: 0,
+	LastKey:  0,
+	KeyStep:  0,
+	Documentation: map[string]any{
+		"summary": "Reports latency statistics about commands.",
+	},
+}
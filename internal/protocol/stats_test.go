@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+func statsOf(name string) CommandStats {
+	for _, cs := range testStats.AllCommandStats() {
+		if cs.Name == name {
+			return cs
+		}
+	}
+	return CommandStats{}
+}
+
+func TestCommandStats(t *testing.T) {
+	testStats.Reset()
+	s := store.NewStore()
+	handle(command("SET", "key", "value"), s, false)
+	handle(command("GET", "key"), s, false)
+	handle(command("INCR", "key"), s, false)
+	// Replayed commands are not counted, and rejected ones do not run.
+	handle(command("SET", "key", "value"), s, true)
+	handle(command("GET"), s, false)
+	s.SetMaxMemory(1, store.NoEviction)
+	handle(command("SET", "other", "value"), s, false)
+	handle(command("NOSUCHCOMMAND"), s, false)
+
+	assert.Equal(t, int64(4), testStats.CommandsProcessed())
+	set := statsOf("set")
+	assert.Equal(t, int64(1), set.Calls)
+	assert.Equal(t, int64(1), set.RejectedCalls)
+	get := statsOf("get")
+	assert.Equal(t, int64(1), get.Calls)
+	assert.Equal(t, int64(1), get.RejectedCalls)
+	incr := statsOf("incr")
+	assert.Equal(t, int64(1), incr.Calls)
+	assert.Equal(t, int64(1), incr.FailedCalls)
+
+	assert.Equal(t, map[string]int64{"ERR": 3, "OOM": 1}, testStats.ErrorStats())
+	assert.Equal(t, int64(4), testStats.ErrorReplies())
+
+	testStats.Reset()
+	assert.Empty(t, testStats.AllCommandStats())
+	assert.Empty(t, testStats.ErrorStats())
+	assert.Zero(t, testStats.ErrorReplies())
+}
+
+func TestPercentile(t *testing.T) {
+	var stats commandStats
+	for i := 0; i < 98; i++ {
+		stats.record(500*time.Nanosecond, false)
+	}
+	stats.record(3*time.Microsecond, false)
+	stats.record(100*time.Millisecond, false)
+
+	testStats.commands.Store("TEST", &stats)
+	defer testStats.Reset()
+	cs := statsOf("test")
+	require.Equal(t, int64(100), cs.Calls)
+	assert.Equal(t, time.Microsecond, cs.Percentile(0.5))
+	assert.Equal(t, 4*time.Microsecond, cs.Percentile(0.99))
+	assert.Equal(t, 131072*time.Microsecond, cs.Percentile(0.999))
+	assert.Zero(t, CommandStats{}.Percentile(0.5))
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	testStats.Reset()
+	defer testStats.Reset()
+	s := store.NewStore()
+	handle(command("SET", "key", "value"), s, false)
+	handle(command("GET", "key"), s, false)
+
+	// The buckets depend on how fast GET ran, but the last one counts it.
+	reply := handle(command("LATENCY", "HISTOGRAM", "get", "nosuchcommand"), s, false)
+	assert.True(t, strings.HasPrefix(reply, "*2\r\n$3\r\nget\r\n*4\r\n$5\r\ncalls\r\n:1\r\n$14\r\nhistogram_usec\r\n*"), reply)
+	assert.True(t, strings.HasSuffix(reply, ":1\r\n"), reply)
+	assert.Equal(t, "*0\r\n", handle(command("LATENCY", "HISTOGRAM", "ping"), s, false))
+	assert.Equal(t, "-ERR unknown subcommand 'nope'. Try LATENCY HELP.\r\n", handle(command("LATENCY", "nope"), s, false))
+}
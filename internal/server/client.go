@@ -0,0 +1,191 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teguhkurnia/redis-like/internal/protocol"
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
+)
+
+// ClientFlags describe the state of a connection, such as being a monitor.
+type ClientFlags uint32
+
+const (
+	// ClientNoEvict is set by CLIENT NO-EVICT. Clients are never evicted
+	// yet, so it is only reported.
+	ClientNoEvict ClientFlags = 1 << iota
+	// ClientCloseASAP marks a killed client whose connection is closing.
+	ClientCloseASAP
+	// ClientMonitor is set once the client sent MONITOR.
+	ClientMonitor
+)
+
+// String returns the flags as CLIENT LIST shows them, one letter each, or N
+// when none is set.
+func (f ClientFlags) String() string {
+	letters := []struct {
+		flag   ClientFlags
+		letter byte
+	}{
+		{ClientMonitor, 'O'},
+		{ClientCloseASAP, 'A'},
+		{ClientNoEvict, 'e'},
+	}
+	var b []byte
+	for _, l := range letters {
+		if f&l.flag != 0 {
+			b = append(b, l.letter)
+		}
+	}
+	if len(b) == 0 {
+		return "N"
+	}
+	return string(b)
+}
+
+// Client is a connection to the server, and the commands.Client handlers
+// see when it sends them a command.
+type Client struct {
+	id        int64
+	srv       *Server
+	conn      net.Conn
+	addr      string
+	laddr     string
+	createdAt time.Time
+
+	// w holds replies until readLoop writes them out. Only the goroutine
+	// serving the connection uses it.
+	w *resp.Writer
+
+	// mu guards the fields below, which other connections may read.
+	mu       sync.Mutex
+	name     string
+	db       int
+	proto    int
+	flags    ClientFlags
+	lastCmd  string
+	lastSeen time.Time
+	// qbuf and omem are the bytes of commands and replies that were pending
+	// when the last command ran, in pipelines.
+	qbuf int
+	omem int
+}
+
+func newClient(id int64, srv *Server, conn net.Conn) *Client {
+	now := time.Now()
+	return &Client{
+		id:        id,
+		srv:       srv,
+		conn:      conn,
+		addr:      conn.RemoteAddr().String(),
+		laddr:     conn.LocalAddr().String(),
+		createdAt: now,
+		w:         resp.NewWriter(resp.RESP2),
+		proto:     resp.RESP2,
+		lastSeen:  now,
+	}
+}
+
+func (c *Client) ID() int64 {
+	return c.id
+}
+
+// Stats returns the command statistics of the client's server.
+func (c *Client) Stats() *protocol.Stats {
+	return c.srv.stats
+}
+
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+func (c *Client) CreatedAt() time.Time {
+	return c.createdAt
+}
+
+func (c *Client) Name() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.name
+}
+
+func (c *Client) SetName(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.name = name
+}
+
+// DB returns the selected database. There is only database 0 for now.
+func (c *Client) DB() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.db
+}
+
+func (c *Client) Protocol() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.proto
+}
+
+// SetProtocol switches the RESP version of the client's replies, starting
+// with the reply being written.
+func (c *Client) SetProtocol(proto int) {
+	c.mu.Lock()
+	c.proto = proto
+	c.mu.Unlock()
+	c.w.SetProtocol(proto)
+}
+
+func (c *Client) Flags() ClientFlags {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flags
+}
+
+func (c *Client) SetFlags(flags ClientFlags, on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if on {
+		c.flags |= flags
+	} else {
+		c.flags &^= flags
+	}
+}
+
+// LastCommand returns the name of the last command the client sent, and
+// when it was sent.
+func (c *Client) LastCommand() (string, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastCmd, c.lastSeen
+}
+
+// setLastCommand records the command about to run, with qbuf bytes of
+// commands behind it and omem bytes of replies before it not yet written.
+func (c *Client) setLastCommand(name string, qbuf, omem int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastCmd = strings.ToLower(name)
+	c.lastSeen = time.Now()
+	c.qbuf = qbuf
+	c.omem = omem
+}
+
+// String describes the client in the format of CLIENT LIST and CLIENT INFO.
+func (c *Client) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	cmd := c.lastCmd
+	if cmd == "" {
+		cmd = "NULL"
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d qbuf=%d omem=%d cmd=%s user=default resp=%d",
+		c.id, c.addr, c.laddr, c.name, int64(now.Sub(c.createdAt).Seconds()), int64(now.Sub(c.lastSeen).Seconds()),
+		c.flags, c.db, c.qbuf, c.omem, cmd, c.proto)
+}
@@ -0,0 +1,311 @@
+package server
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/teguhkurnia/redis-like/internal/protocol/commands"
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+var clientHelp = []string{
+	"CLIENT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"GETNAME",
+	"    Return the name of the current connection.",
+	"ID",
+	"    Return the ID of the current connection.",
+	"INFO",
+	"    Return information about the current client connection.",
+	"KILL <ip:port>",
+	"    Kill connection made from <ip:port>.",
+	"KILL <option> <value> [<option> <value> [...]]",
+	"    Kill connections. Options are:",
+	"    * ADDR (<ip:port>|<unixsocket>:0)",
+	"      Kill connections made from the specified address",
+	"    * LADDR (<ip:port>|<unixsocket>:0)",
+	"      Kill connections made to specified local address",
+	"    * ID <client-id>",
+	"      Kill connections by client id.",
+	"    * USER <username>",
+	"      Kill connections authenticated by <username>.",
+	"    * SKIPME (YES|NO)",
+	"      Skip killing current connection (default: yes).",
+	"    * MAXAGE <maxage>",
+	"      Kill connections older than the specified age.",
+	"LIST [options ...]",
+	"    Return information about client connections. Options:",
+	"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+	"      Return clients of specified type.",
+	"    * ID <client-id> [<client-id> ...]",
+	"      Return clients of specified IDs only.",
+	"PAUSE <timeout> [WRITE|ALL]",
+	"    Suspend all, or just write, clients for <timeout> milliseconds.",
+	"UNPAUSE",
+	"    Stop the current client pause, resuming traffic.",
+	"SETNAME <name>",
+	"    Assign the name <name> to the current connection.",
+	"NO-EVICT (ON|OFF)",
+	"    Protect current client connection from eviction.",
+	"HELP",
+	"    Print this help.",
+}
+
+// subcommand returns the upper-case subcommand of a container command like
+// CLIENT, after checking its number of arguments against arity, which holds
+// the arity of every subcommand counted like CommandSpec.Arity. It writes
+// the error and returns false when cmd is not a valid subcommand.
+func subcommand(w *resp.Writer, cmd *commands.Command, arity map[string]int) (string, bool) {
+	name := strings.ToUpper(string(cmd.Args[0]))
+	want, found := arity[name]
+	if !found {
+		w.Errorf("ERR unknown subcommand '%s'. Try %s HELP.", cmd.Args[0], cmd.Name)
+		return "", false
+	}
+	n := len(cmd.Args) + 1
+	if (want > 0 && n != want) || (want < 0 && n < -want) {
+		w.Errorf("ERR wrong number of arguments for '%s|%s' command", strings.ToLower(cmd.Name), strings.ToLower(name))
+		return "", false
+	}
+	return name, true
+}
+
+// writeHelp writes the reply of a HELP subcommand.
+func writeHelp(w *resp.Writer, lines []string) {
+	w.Array(len(lines))
+	for _, line := range lines {
+		w.Status(line)
+	}
+}
+
+// clientArity is the number of arguments of each CLIENT subcommand, counted
+// like CommandSpec.Arity.
+var clientArity = map[string]int{
+	"HELP":     2,
+	"ID":       2,
+	"INFO":     2,
+	"GETNAME":  2,
+	"SETNAME":  3,
+	"LIST":     -2,
+	"KILL":     -3,
+	"PAUSE":    -3,
+	"UNPAUSE":  2,
+	"NO-EVICT": 3,
+}
+
+// handleClient implements CLIENT, which inspects and manages the
+// connections to the server sending it.
+func handleClient(client commands.Client, cmd *commands.Command, store *store.Store, w *resp.Writer) {
+	c := client.(*Client)
+	name, ok := subcommand(w, cmd, clientArity)
+	if !ok {
+		return
+	}
+	args := cmd.Args[1:]
+
+	switch name {
+	case "HELP":
+		writeHelp(w, clientHelp)
+	case "ID":
+		w.Int(c.ID())
+	case "INFO":
+		w.Verbatim("txt", c.String()+"\n")
+	case "GETNAME":
+		if name := c.Name(); name != "" {
+			w.BulkString(name)
+		} else {
+			w.Null()
+		}
+	case "SETNAME":
+		if !commands.ValidClientName(args[0]) {
+			w.Error(commands.ErrInvalidClientName)
+			return
+		}
+		c.SetName(string(args[0]))
+		w.Status("OK")
+	case "LIST":
+		clientList(c.srv, args, w)
+	case "KILL":
+		clientKill(c, args, w)
+	case "PAUSE":
+		timeout, err := strconv.ParseInt(string(args[0]), 10, 64)
+		if err != nil {
+			w.Error("ERR timeout is not an integer or out of range")
+			return
+		}
+		if timeout < 0 {
+			w.Error("ERR timeout is negative")
+			return
+		}
+		all := true
+		if len(args) == 2 && strings.EqualFold(string(args[1]), "WRITE") {
+			all = false
+		} else if len(args) > 2 || (len(args) == 2 && !strings.EqualFold(string(args[1]), "ALL")) {
+			w.Error("ERR syntax error")
+			return
+		}
+		c.srv.pause.start(time.Duration(timeout)*time.Millisecond, all)
+		w.Status("OK")
+	case "UNPAUSE":
+		c.srv.pause.stop()
+		w.Status("OK")
+	case "NO-EVICT":
+		switch strings.ToUpper(string(args[0])) {
+		case "ON":
+			c.SetFlags(ClientNoEvict, true)
+		case "OFF":
+			c.SetFlags(ClientNoEvict, false)
+		default:
+			w.Error("ERR syntax error")
+			return
+		}
+		w.Status("OK")
+	}
+}
+
+// clientList writes CLIENT LIST, filtered by TYPE and ID options. Every
+// client is a normal one, as there is no replication or pub/sub.
+func clientList(s *Server, args [][]byte, w *resp.Writer) {
+	var ids []int64
+	normal := true
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch {
+		case option == "TYPE" && i+1 < len(args):
+			switch strings.ToUpper(string(args[i+1])) {
+			case "NORMAL":
+			case "MASTER", "REPLICA", "SLAVE", "PUBSUB":
+				normal = false
+			default:
+				w.Errorf("ERR Unknown client type '%s'", args[i+1])
+				return
+			}
+			i++
+		case option == "ID" && i+1 < len(args):
+			for i++; i < len(args); i++ {
+				id, err := strconv.ParseInt(string(args[i]), 10, 64)
+				if err != nil || id <= 0 {
+					w.Error("ERR Invalid client ID")
+					return
+				}
+				ids = append(ids, id)
+			}
+		default:
+			w.Error("ERR syntax error")
+			return
+		}
+	}
+
+	var b strings.Builder
+	if normal {
+		for _, c := range s.clientList() {
+			if ids == nil || slices.Contains(ids, c.id) {
+				b.WriteString(c.String())
+				b.WriteByte('\n')
+			}
+		}
+	}
+	w.Verbatim("txt", b.String())
+}
+
+// clientKill implements both forms of CLIENT KILL: the old one, with only an
+// address, and the one taking filters, which replies with how many clients
+// it killed.
+func clientKill(self *Client, args [][]byte, w *resp.Writer) {
+	if len(args) == 1 {
+		for _, c := range self.srv.clientList() {
+			if c.addr == string(args[0]) {
+				self.srv.killClient(c, self)
+				w.Status("OK")
+				return
+			}
+		}
+		w.Error("ERR No such client")
+		return
+	}
+	if len(args)%2 != 0 {
+		w.Error("ERR syntax error")
+		return
+	}
+
+	var (
+		id          int64
+		addr, laddr string
+		maxAge      int64
+		skipMe      = true
+	)
+	for i := 0; i < len(args); i += 2 {
+		value := string(args[i+1])
+		switch strings.ToUpper(string(args[i])) {
+		case "ID":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n <= 0 {
+				w.Error("ERR client-id should be greater than 0")
+				return
+			}
+			id = n
+		case "ADDR":
+			addr = value
+		case "LADDR":
+			laddr = value
+		case "USER":
+			// Every client is authenticated as the default user.
+			if value != "default" {
+				w.Errorf("ERR No such user '%s'", value)
+				return
+			}
+		case "SKIPME":
+			switch strings.ToUpper(value) {
+			case "YES":
+				skipMe = true
+			case "NO":
+				skipMe = false
+			default:
+				w.Error("ERR syntax error")
+				return
+			}
+		case "MAXAGE":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				w.Error("ERR value is not an integer or out of range")
+				return
+			}
+			maxAge = n
+		default:
+			w.Error("ERR syntax error")
+			return
+		}
+	}
+
+	killed := 0
+	for _, c := range self.srv.clientList() {
+		switch {
+		case id != 0 && c.id != id,
+			addr != "" && c.addr != addr,
+			laddr != "" && c.laddr != laddr,
+			maxAge != 0 && time.Since(c.createdAt) < time.Duration(maxAge)*time.Second,
+			skipMe && c == self:
+			continue
+		}
+		self.srv.killClient(c, self)
+		killed++
+	}
+	w.Int(int64(killed))
+}
+
+// ClientSpec is the CLIENT command. Its handler needs the *Client serving
+// the connection, so it is registered by the server rather than with the
+// other commands.
+var ClientSpec = &commands.CommandSpec{
+	Handler:  handleClient,
+	Arity:    -2,
+	Flags:    []string{"noscript", "loading", "stale"},
+	FirstKey: 0,
+	LastKey:  0,
+	KeyStep:  0,
+	Documentation: map[string]any{
+		"summary": "A container for client connection commands.",
+	},
+}
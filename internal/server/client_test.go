@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teguhkurnia/redis-like/internal/protocol/parser"
+)
+
+// conversation sends inline commands on one connection and reads their
+// replies.
+type conversation struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func talk(t *testing.T, conn net.Conn) *conversation {
+	return &conversation{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *conversation) send(line string) {
+	go c.conn.Write([]byte(line + "\r\n"))
+}
+
+func (c *conversation) read() parser.RESPValue {
+	value, err := parser.ParseNextValue(c.reader)
+	require.NoError(c.t, err)
+	return value
+}
+
+// call sends line and returns its reply.
+func (c *conversation) call(line string) parser.RESPValue {
+	c.send(line)
+	return c.read()
+}
+
+func TestClientCommands(t *testing.T) {
+	_, conn, _ := serve(t)
+	c := talk(t, conn)
+
+	assert.Equal(t, int64(1), c.call("CLIENT ID").Int)
+	assert.Nil(t, c.call("CLIENT GETNAME").Bulk)
+	assert.Equal(t, "OK", c.call("CLIENT SETNAME worker").Str)
+	assert.Equal(t, "worker", string(c.call("CLIENT GETNAME").Bulk))
+	assert.Equal(t, byte('-'), c.call(`CLIENT SETNAME "my worker"`).Type)
+
+	list := string(c.call("CLIENT LIST").Bulk)
+	assert.Regexp(t, `^id=1 addr=pipe laddr=pipe name=worker age=\d+ idle=0 flags=N db=0 qbuf=0 omem=0 cmd=client user=default resp=2\n$`, list)
+	assert.Equal(t, list, string(c.call("CLIENT INFO").Bulk))
+	assert.Empty(t, c.call("CLIENT LIST ID 2").Bulk)
+	assert.Empty(t, c.call("CLIENT LIST TYPE pubsub").Bulk)
+
+	assert.Equal(t, "OK", c.call("CLIENT NO-EVICT on").Str)
+	assert.Contains(t, string(c.call("CLIENT INFO").Bulk), " flags=e ")
+	assert.Equal(t, "OK", c.call("CLIENT NO-EVICT off").Str)
+
+	assert.Equal(t, "ERR unknown subcommand 'nope'. Try CLIENT HELP.", c.call("CLIENT nope").Str)
+	assert.Equal(t, "ERR wrong number of arguments for 'client|setname' command", c.call("CLIENT SETNAME").Str)
+	assert.Equal(t, "ERR wrong number of arguments for 'client' command", c.call("CLIENT").Str)
+	assert.NotEmpty(t, c.call("CLIENT HELP").Array)
+}
+
+func TestClientKill(t *testing.T) {
+	s, conn, _ := serve(t)
+	c := talk(t, conn)
+	_, otherDone := connect(t, s)
+	_, thirdDone := connect(t, s)
+
+	assert.Equal(t, int64(1), c.call("CLIENT KILL ID 2").Int)
+	waitDone(t, otherDone)
+	assert.Equal(t, int64(0), c.call("CLIENT KILL ID 2").Int)
+	assert.Equal(t, "ERR No such user 'admin'", c.call("CLIENT KILL USER admin").Str)
+
+	// The client sending KILL is skipped unless asked otherwise, and then
+	// gets its reply before being closed.
+	assert.Equal(t, int64(1), c.call("CLIENT KILL USER default").Int)
+	waitDone(t, thirdDone)
+	assert.Equal(t, int64(1), c.call("CLIENT KILL ID 1 SKIPME no").Int)
+	_, err := c.reader.ReadByte()
+	assert.Error(t, err)
+	assert.Empty(t, s.clientList())
+}
+
+func TestClientPause(t *testing.T) {
+	s, conn, _ := serve(t)
+	admin := talk(t, conn)
+	other, _ := connect(t, s)
+	c := talk(t, other)
+
+	assert.Equal(t, "OK", admin.call("CLIENT PAUSE 10000 WRITE").Str)
+	// Reads go on, writes wait for UNPAUSE.
+	assert.Equal(t, "PONG", c.call("PING").Str)
+	c.send("SET key value")
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, s.Store.Exists("key"))
+	assert.Equal(t, "OK", admin.call("CLIENT UNPAUSE").Str)
+	assert.Equal(t, "OK", c.read().Str)
+
+	// A pause ends by itself, and ALL holds up reads too.
+	assert.Equal(t, "OK", admin.call("CLIENT PAUSE 50").Str)
+	start := time.Now()
+	assert.Equal(t, "PONG", c.call("PING").Str)
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+
+	assert.Equal(t, "ERR timeout is negative", admin.call("CLIENT PAUSE -1").Str)
+	assert.Equal(t, "ERR syntax error", admin.call("CLIENT PAUSE 10 READ").Str)
+}
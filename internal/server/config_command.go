@@ -0,0 +1,164 @@
+package server
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/teguhkurnia/redis-like/internal/config"
+	"github.com/teguhkurnia/redis-like/internal/protocol/commands"
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+// Config returns a copy of the server's current configuration.
+func (s *Server) Config() *config.Config {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	return s.config.Clone()
+}
+
+// applyConfig makes cfg the configuration of s, passing the settings that
+// can change at runtime on to the store and the log. The caller holds
+// configMu, unless s is not serving yet.
+func (s *Server) applyConfig(cfg *config.Config) {
+	s.Store.SetMaxMemory(cfg.MaxMemory, cfg.MaxMemoryPolicy)
+	if s.Log != nil {
+		s.Log.SetFsync(cfg.AppendFsync)
+		s.Log.SetSaveRules(cfg.Save)
+		s.Log.SetAutoRewrite(cfg.AutoAOFRewritePercentage, cfg.AutoAOFRewriteMinSize)
+	}
+	s.stats.SetSlowlog(cfg.SlowlogLogSlowerThan, cfg.SlowlogMaxLen)
+	s.hz.Store(int64(cfg.Hz))
+	s.config = cfg
+}
+
+// resetStats zeroes the statistics reported by INFO, as CONFIG RESETSTAT
+// does.
+func (s *Server) resetStats() {
+	s.Store.ResetStats()
+	s.stats.Reset()
+	s.totalConnections.Store(0)
+}
+
+var configHelp = []string{
+	"CONFIG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"GET <pattern>",
+	"    Return parameters matching the glob-like <pattern> and their values.",
+	"SET <directive> <value>",
+	"    Set the configuration <directive> to <value>.",
+	"RESETSTAT",
+	"    Reset statistics reported by the INFO command.",
+	"REWRITE",
+	"    Rewrite the configuration file.",
+	"HELP",
+	"    Print this help.",
+}
+
+var configArity = map[string]int{
+	"HELP":      2,
+	"GET":       -3,
+	"SET":       -4,
+	"REWRITE":   2,
+	"RESETSTAT": 2,
+}
+
+// handleConfig implements CONFIG, which reads and changes the server
+// configuration at runtime.
+func handleConfig(client commands.Client, cmd *commands.Command, store *store.Store, w *resp.Writer) {
+	s := client.(*Client).srv
+	name, ok := subcommand(w, cmd, configArity)
+	if !ok {
+		return
+	}
+	args := cmd.Args[1:]
+
+	switch name {
+	case "HELP":
+		writeHelp(w, configHelp)
+	case "GET":
+		cfg := s.Config()
+		settings := make(map[string]string)
+		for _, pattern := range args {
+			for name, value := range cfg.Get(string(pattern)) {
+				settings[name] = value
+			}
+		}
+		names := make([]string, 0, len(settings))
+		for name := range settings {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		w.Map(len(names))
+		for _, name := range names {
+			w.BulkString(name)
+			w.BulkString(settings[name])
+		}
+	case "SET":
+		if len(args)%2 != 0 {
+			w.Error("ERR wrong number of arguments for 'config|set' command")
+			return
+		}
+		configSet(s, args, w)
+	case "REWRITE":
+		cfg := s.Config()
+		if cfg.File == "" {
+			w.Error("ERR The server is running without a config file")
+			return
+		}
+		if err := cfg.Rewrite(); err != nil {
+			w.Errorf("ERR Rewriting config file: %s", err)
+			return
+		}
+		w.Status("OK")
+	case "RESETSTAT":
+		s.resetStats()
+		w.Status("OK")
+	}
+}
+
+// configSet applies every name and value pair in args, or none of them if
+// one is refused.
+func configSet(s *Server, args [][]byte, w *resp.Writer) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	cfg := s.config.Clone()
+	seen := make(map[string]bool)
+	for i := 0; i < len(args); i += 2 {
+		name := strings.ToLower(string(args[i]))
+		if !slices.Contains(config.Names(), name) {
+			w.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", args[i])
+			return
+		}
+		var err string
+		switch {
+		case seen[name]:
+			err = "duplicate parameter"
+		case config.IsImmutable(name):
+			err = config.ErrImmutable.Error()
+		default:
+			if setErr := cfg.Set(name, string(args[i+1])); setErr != nil {
+				err = setErr.Error()
+			}
+		}
+		if err != "" {
+			w.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %s", args[i], err)
+			return
+		}
+		seen[name] = true
+	}
+	s.applyConfig(cfg)
+	w.Status("OK")
+}
+
+// ConfigSpec is the CONFIG command, registered by the server it configures.
+var ConfigSpec = &commands.CommandSpec{
+	Handler:  handleConfig,
+	Arity:    -2,
+	Flags:    []string{"admin", "noscript", "loading", "stale"},
+	FirstKey: 0,
+	LastKey:  0,
+	KeyStep:  0,
+	Documentation: map[string]any{
+		"summary": "A container for server configuration commands.",
+	},
+}
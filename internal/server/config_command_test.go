@@ -0,0 +1,124 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teguhkurnia/redis-like/internal/config"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+// pairs returns the strings of a map reply by key.
+func pairs(t *testing.T, c *conversation, line string) map[string]string {
+	value := c.call(line)
+	require.Equal(t, byte('*'), value.Type, "%s: %+v", line, value)
+	result := make(map[string]string)
+	for i := 0; i+1 < len(value.Array); i += 2 {
+		result[string(value.Array[i].Bulk)] = string(value.Array[i+1].Bulk)
+	}
+	return result
+}
+
+func TestConfigGetSet(t *testing.T) {
+	s, conn, _ := serve(t)
+	c := talk(t, conn)
+
+	assert.Equal(t, map[string]string{"maxmemory": "0", "maxmemory-policy": "noeviction"}, pairs(t, c, "CONFIG GET maxmemory*"))
+	assert.Equal(t, map[string]string{"hz": "10", "port": "8080"}, pairs(t, c, "CONFIG GET hz port"))
+	assert.Empty(t, pairs(t, c, "CONFIG GET nosuchoption"))
+
+	assert.Equal(t, "OK", c.call("CONFIG SET maxmemory 1mb maxmemory-policy allkeys-lru hz 50").Str)
+	assert.Equal(t, int64(1<<20), s.Store.Stats().MaxMemory)
+	assert.Equal(t, store.AllKeysLRU, s.Store.Stats().EvictionPolicy)
+	assert.Equal(t, int64(50), s.hz.Load())
+	assert.Equal(t, "1048576", pairs(t, c, "CONFIG GET maxmemory")["maxmemory"])
+
+	// A refused setting leaves the others unchanged too.
+	for line, want := range map[string]string{
+		"CONFIG SET maxmemory 2mb hz 0":        "ERR CONFIG SET failed (possibly related to argument 'hz') - argument must be between 1 and 500 inclusive",
+		"CONFIG SET maxmemory 2mb port 7000":   "ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config",
+		"CONFIG SET maxmemory 2mb maxmemory 3": "ERR CONFIG SET failed (possibly related to argument 'maxmemory') - duplicate parameter",
+		"CONFIG SET maxmemory 2mb nosuch 1":    "ERR Unknown option or number of arguments for CONFIG SET - 'nosuch'",
+		"CONFIG SET maxmemory 2mb hz":          "ERR wrong number of arguments for 'config|set' command",
+	} {
+		assert.Equal(t, want, c.call(line).Str, line)
+	}
+	assert.Equal(t, int64(1<<20), s.Store.Stats().MaxMemory)
+
+	assert.Equal(t, "ERR The server is running without a config file", c.call("CONFIG REWRITE").Str)
+}
+
+func TestConfigRewrite(t *testing.T) {
+	s, conn, _ := serve(t)
+	c := talk(t, conn)
+	filename := filepath.Join(t.TempDir(), "redis.conf")
+	require.NoError(t, os.WriteFile(filename, []byte("# Settings\nhz 20\n"), 0644))
+	cfg, err := config.Load(filename)
+	require.NoError(t, err)
+	s.applyConfig(cfg)
+
+	assert.Equal(t, "OK", c.call("CONFIG SET hz 30 appendfsync always").Str)
+	assert.Equal(t, "OK", c.call("CONFIG REWRITE").Str)
+	content, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "# Settings\nhz 30\n# Generated by CONFIG REWRITE\nappendfsync always\n", string(content))
+}
+
+func TestConfigResetStat(t *testing.T) {
+	s, conn, _ := serve(t)
+	c := talk(t, conn)
+	s.Store.Set("key", "value")
+	s.Store.SetMaxMemory(1, store.AllKeysRandom)
+	s.Store.Evict()
+	require.Equal(t, int64(1), s.Store.Stats().EvictedKeys)
+
+	assert.Equal(t, "OK", c.call("CONFIG RESETSTAT").Str)
+	assert.Zero(t, s.Store.Stats().EvictedKeys)
+}
+
+func TestConfigResetStatKeepsOtherServers(t *testing.T) {
+	s, conn, _ := serve(t)
+	c := talk(t, conn)
+	other, otherConn, _ := serve(t)
+	o := talk(t, otherConn)
+	c.call("GET key")
+	o.call("SET key value")
+
+	assert.Equal(t, "OK", c.call("CONFIG RESETSTAT").Str)
+	assert.Zero(t, s.stats.CommandsProcessed())
+	commands := other.stats.AllCommandStats()
+	require.Len(t, commands, 1)
+	assert.Equal(t, "set", commands[0].Name)
+	assert.Equal(t, int64(1), commands[0].Calls)
+}
+
+func TestConfigSetSlowlog(t *testing.T) {
+	_, conn, _ := serve(t)
+	c := talk(t, conn)
+	c.call("CLIENT SETNAME worker")
+	assert.Equal(t, "OK", c.call("CONFIG SET slowlog-log-slower-than 0 slowlog-max-len 1").Str)
+	c.call("SET key value")
+
+	entries := c.call("SLOWLOG GET").Array
+	require.Len(t, entries, 1)
+	entry := entries[0].Array
+	require.Len(t, entry, 6)
+	require.Len(t, entry[3].Array, 3)
+	assert.Equal(t, "SET", string(entry[3].Array[0].Bulk))
+	assert.Equal(t, "pipe", string(entry[4].Bulk))
+	assert.Equal(t, "worker", string(entry[5].Bulk))
+
+	// Other servers keep their own slow log and settings.
+	other, otherConn, _ := serve(t)
+	o := talk(t, otherConn)
+	assert.Equal(t, int64(0), o.call("SLOWLOG LEN").Int)
+	assert.Equal(t, "10000", pairs(t, o, "CONFIG GET slowlog-log-slower-than")["slowlog-log-slower-than"])
+	other.applyConfig(config.Default())
+	c.call("SET other value")
+	entries = c.call("SLOWLOG GET").Array
+	require.Len(t, entries, 1)
+	assert.Equal(t, "other", string(entries[0].Array[3].Array[1].Bulk))
+}
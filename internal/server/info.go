@@ -0,0 +1,242 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/teguhkurnia/redis-like/internal/protocol/commands"
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+// newRunID returns a random identifier for this run of the server, 40 hex
+// characters like in Redis.
+func newRunID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// infoBuilder accumulates the lines of INFO sections.
+type infoBuilder struct {
+	strings.Builder
+}
+
+func (b *infoBuilder) field(name string, value any) {
+	fmt.Fprintf(b, "%s:%v\r\n", name, value)
+}
+
+// infoSection is a section of INFO. Sections are returned in the order of
+// infoSections.
+type infoSection struct {
+	name string
+	// byDefault sections are returned by INFO without arguments.
+	byDefault bool
+	write     func(s *Server, b *infoBuilder)
+}
+
+var infoSections = []infoSection{
+	{"server", true, (*Server).infoServer},
+	{"clients", true, (*Server).infoClients},
+	{"memory", true, (*Server).infoMemory},
+	{"persistence", true, (*Server).infoPersistence},
+	{"stats", true, (*Server).infoStats},
+	{"commandstats", false, (*Server).infoCommandStats},
+	{"errorstats", true, (*Server).infoErrorStats},
+	{"latencystats", false, (*Server).infoLatencyStats},
+	{"keyspace", true, (*Server).infoKeyspace},
+}
+
+// Info returns the INFO sections asked for: by name, "default", "all" or
+// "everything". No section means the default ones.
+func (s *Server) Info(sections ...string) string {
+	wanted := make(map[string]bool)
+	for _, name := range sections {
+		wanted[strings.ToLower(name)] = true
+	}
+	all := wanted["all"] || wanted["everything"]
+	byDefault := len(sections) == 0 || wanted["default"]
+
+	var b infoBuilder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] && !(byDefault && section.byDefault) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		section.write(s, &b)
+	}
+	return b.String()
+}
+
+func (s *Server) infoServer(b *infoBuilder) {
+	cfg := s.Config()
+	uptime := time.Since(s.startTime)
+	b.field("redis_version", commands.RedisVersion)
+	b.field("redis_mode", "standalone")
+	b.field("os", runtime.GOOS+" "+runtime.GOARCH)
+	b.field("arch_bits", strconv.IntSize)
+	b.field("go_version", runtime.Version())
+	b.field("process_id", os.Getpid())
+	b.field("run_id", s.runID)
+	b.field("tcp_port", cfg.Port)
+	b.field("server_time_usec", time.Now().UnixMicro())
+	b.field("uptime_in_seconds", int64(uptime.Seconds()))
+	b.field("uptime_in_days", int64(uptime.Hours()/24))
+	b.field("hz", cfg.Hz)
+	b.field("config_file", cfg.File)
+}
+
+func (s *Server) infoClients(b *infoBuilder) {
+	s.mu.Lock()
+	connected := len(s.clients)
+	s.mu.Unlock()
+	b.field("connected_clients", connected)
+	b.field("blocked_clients", 0)
+}
+
+func (s *Server) infoMemory(b *infoBuilder) {
+	stats := s.Store.Stats()
+	b.field("used_memory", stats.UsedMemory)
+	b.field("used_memory_human", bytesToHuman(stats.UsedMemory))
+	b.field("used_memory_peak", stats.UsedMemoryPeak)
+	b.field("used_memory_peak_human", bytesToHuman(stats.UsedMemoryPeak))
+	b.field("maxmemory", stats.MaxMemory)
+	b.field("maxmemory_human", bytesToHuman(stats.MaxMemory))
+	b.field("maxmemory_policy", stats.EvictionPolicy)
+}
+
+func (s *Server) infoPersistence(b *infoBuilder) {
+	b.field("loading", boolToInt(s.loading.Load()))
+	if s.Log == nil {
+		b.field("aof_enabled", 0)
+		return
+	}
+	stats := s.Log.Stats()
+	b.field("rdb_changes_since_last_save", stats.ChangesSinceLastSave)
+	b.field("rdb_bgsave_in_progress", boolToInt(stats.SaveInProgress))
+	b.field("rdb_last_save_time", stats.LastSave.Unix())
+	b.field("rdb_last_bgsave_status", status(stats.LastSaveOK))
+	b.field("aof_enabled", 1)
+	b.field("aof_rewrite_in_progress", boolToInt(stats.RewriteInProgress))
+	b.field("aof_last_bgrewrite_status", status(stats.LastRewriteOK))
+	b.field("aof_last_write_status", status(stats.LastWriteOK))
+	b.field("aof_current_size", stats.CurrentSize)
+	b.field("aof_base_size", stats.BaseSize)
+}
+
+func (s *Server) infoStats(b *infoBuilder) {
+	stats := s.Store.Stats()
+	b.field("total_connections_received", s.totalConnections.Load())
+	b.field("total_commands_processed", s.stats.CommandsProcessed())
+	b.field("expired_keys", stats.ExpiredKeys)
+	b.field("expired_stale_perc", strconv.FormatFloat(stats.ExpiredStalePerc, 'f', 2, 64))
+	b.field("expired_time_cap_reached_count", stats.ExpiredTimeCapReachedCount)
+	b.field("evicted_keys", stats.EvictedKeys)
+	b.field("keyspace_hits", stats.KeyspaceHits)
+	b.field("keyspace_misses", stats.KeyspaceMisses)
+	b.field("total_error_replies", s.stats.ErrorReplies())
+}
+
+func (s *Server) infoCommandStats(b *infoBuilder) {
+	for _, cs := range s.stats.AllCommandStats() {
+		var perCall float64
+		if cs.Calls > 0 {
+			perCall = float64(cs.Usec) / float64(cs.Calls)
+		}
+		b.field("cmdstat_"+cs.Name, fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d",
+			cs.Calls, cs.Usec, perCall, cs.RejectedCalls, cs.FailedCalls))
+	}
+}
+
+func (s *Server) infoErrorStats(b *infoBuilder) {
+	errors := s.stats.ErrorStats()
+	for _, code := range slices.Sorted(maps.Keys(errors)) {
+		b.field("errorstat_"+code, fmt.Sprintf("count=%d", errors[code]))
+	}
+}
+
+// infoLatencyStats reports the percentiles of each command's latency, as
+// the upper bound of the histogram bucket they fall in.
+func (s *Server) infoLatencyStats(b *infoBuilder) {
+	usec := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Microsecond)
+	}
+	for _, cs := range s.stats.AllCommandStats() {
+		if cs.Calls == 0 {
+			continue
+		}
+		b.field("latency_percentiles_usec_"+cs.Name, fmt.Sprintf("p50=%.3f,p99=%.3f,p99.9=%.3f",
+			usec(cs.Percentile(0.5)), usec(cs.Percentile(0.99)), usec(cs.Percentile(0.999))))
+	}
+}
+
+// infoKeyspace has a line for every database holding keys. There is only
+// database 0.
+func (s *Server) infoKeyspace(b *infoBuilder) {
+	stats := s.Store.Stats()
+	if stats.Keys > 0 {
+		b.field("db0", fmt.Sprintf("keys=%d,expires=%d", stats.Keys, stats.Expires))
+	}
+}
+
+// bytesToHuman formats n the way INFO does, e.g. 1.50M.
+func bytesToHuman(n int64) string {
+	units := []string{"B", "K", "M", "G", "T", "P"}
+	value := float64(n)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.2f%s", value, units[i])
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func status(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "err"
+}
+
+// handleInfo implements INFO [section ...].
+func handleInfo(client commands.Client, cmd *commands.Command, store *store.Store, w *resp.Writer) {
+	sections := make([]string, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		sections[i] = string(arg)
+	}
+	w.Verbatim("txt", client.(*Client).srv.Info(sections...))
+}
+
+// InfoSpec is the INFO command, registered by the server it describes.
+var InfoSpec = &commands.CommandSpec{
+	Handler:  handleInfo,
+	Arity:    -1,
+	Flags:    []string{"loading", "stale"},
+	FirstKey: 0,
+	LastKey:  0,
+	KeyStep:  0,
+	Documentation: map[string]any{
+		"summary": "Returns information and statistics about the server.",
+	},
+}
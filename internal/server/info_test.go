@@ -0,0 +1,81 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// info returns the sections of an INFO reply by name, each holding its
+// fields.
+func info(t *testing.T, c *conversation, line string) map[string]map[string]string {
+	value := c.call(line)
+	require.Equal(t, byte('$'), value.Type, "%s: %+v", line, value)
+	sections := make(map[string]map[string]string)
+	var current map[string]string
+	for _, l := range strings.Split(string(value.Bulk), "\r\n") {
+		switch {
+		case strings.HasPrefix(l, "# "):
+			current = make(map[string]string)
+			sections[strings.ToLower(l[2:])] = current
+		case l != "":
+			key, value, found := strings.Cut(l, ":")
+			require.True(t, found, l)
+			current[key] = value
+		}
+	}
+	return sections
+}
+
+func TestInfo(t *testing.T) {
+	s, conn, _ := serve(t)
+	c := talk(t, conn)
+	c.call("SET key value")
+	c.call("SET other value EX 100")
+	c.call("GET key")
+	c.call("GET missing")
+
+	sections := info(t, c, "INFO")
+	assert.ElementsMatch(t, []string{"server", "clients", "memory", "persistence", "stats", "errorstats", "keyspace"}, keys(sections))
+	assert.Equal(t, "8080", sections["server"]["tcp_port"])
+	assert.Len(t, sections["server"]["run_id"], 40)
+	assert.Equal(t, "1", sections["clients"]["connected_clients"])
+	assert.Equal(t, "noeviction", sections["memory"]["maxmemory_policy"])
+	assert.Equal(t, "0", sections["persistence"]["loading"])
+	assert.Equal(t, "1", sections["stats"]["keyspace_hits"])
+	assert.Equal(t, "1", sections["stats"]["keyspace_misses"])
+	assert.Equal(t, "1", sections["stats"]["total_connections_received"])
+	assert.Equal(t, "keys=2,expires=1", sections["keyspace"]["db0"])
+
+	// Sections are picked case-insensitively, unknown ones are ignored.
+	sections = info(t, c, "INFO STATS keyspace nosuchsection")
+	assert.ElementsMatch(t, []string{"stats", "keyspace"}, keys(sections))
+	assert.Len(t, info(t, c, "INFO all"), 9)
+
+	c.call("GET")
+	c.call("NOSUCHCOMMAND")
+	sections = info(t, c, "INFO commandstats errorstats latencystats")
+	assert.Regexp(t, `^calls=2,usec=\d+,usec_per_call=[\d.]+,rejected_calls=1,failed_calls=0$`, sections["commandstats"]["cmdstat_get"])
+	assert.Equal(t, "count=2", sections["errorstats"]["errorstat_ERR"])
+	assert.Regexp(t, `^p50=[\d.]+,p99=[\d.]+,p99.9=[\d.]+$`, sections["latencystats"]["latency_percentiles_usec_set"])
+
+	s.resetStats()
+	assert.Equal(t, "0", info(t, c, "INFO stats")["stats"]["keyspace_hits"])
+}
+
+func TestBytesToHuman(t *testing.T) {
+	assert.Equal(t, "0B", bytesToHuman(0))
+	assert.Equal(t, "1023B", bytesToHuman(1023))
+	assert.Equal(t, "1.50K", bytesToHuman(1536))
+	assert.Equal(t, "1.00M", bytesToHuman(1<<20))
+}
+
+func keys(m map[string]map[string]string) []string {
+	result := make([]string, 0, len(m))
+	for key := range m {
+		result = append(result, key)
+	}
+	return result
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricsBuckets is how many latency buckets of each command are exported,
+// from 1µs up to about a second. Slower calls only count in +Inf.
+const metricsBuckets = 21
+
+// startMetrics serves metrics over HTTP on addr until the server stops.
+func (s *Server) startMetrics(addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		panic(err)
+	}
+	srv := &http.Server{Handler: s.metricsHandler(), ReadHeaderTimeout: 10 * time.Second}
+	s.mu.Lock()
+	s.metrics = srv
+	s.metricsAddr = ln.Addr()
+	s.mu.Unlock()
+	if s.stopping.Load() {
+		// Stopped while starting; stop may have missed the server.
+		srv.Close()
+	}
+	go srv.Serve(ln)
+	fmt.Printf("📈 Metrics served on http://%s/metrics\n", ln.Addr())
+}
+
+// MetricsAddr returns the address metrics are served on, once the server
+// has started with metrics-addr set.
+func (s *Server) MetricsAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.metricsAddr
+}
+
+// metricsHandler serves /metrics in the Prometheus text format, /healthz,
+// which is fine as long as the process answers, and /readyz, which is fine
+// once the AOF is loaded and the server accepts connections.
+func (s *Server) metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(s.metricsText())
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case s.loading.Load():
+			http.Error(w, "loading", http.StatusServiceUnavailable)
+		case s.stopping.Load():
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		case s.Addr() == nil:
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+		default:
+			fmt.Fprintln(w, "ok")
+		}
+	})
+	return mux
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsBuffer writes metrics in the Prometheus text format.
+type metricsBuffer struct {
+	bytes.Buffer
+}
+
+// family starts the metric called name.
+func (b *metricsBuffer) family(name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a value of a metric. labels alternate label names and
+// values.
+func (b *metricsBuffer) sample(name string, value float64, labels ...string) {
+	b.WriteString(name)
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i == 0 {
+			b.WriteByte('{')
+		} else {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(b, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+	}
+	if len(labels) > 0 {
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	b.WriteByte('\n')
+}
+
+// metric writes a metric with a single value.
+func (b *metricsBuffer) metric(name, kind, help string, value float64) {
+	b.family(name, kind, help)
+	b.sample(name, value)
+}
+
+func (s *Server) metricsText() []byte {
+	var b metricsBuffer
+	s.mu.Lock()
+	connected := len(s.clients)
+	s.mu.Unlock()
+
+	b.metric("redis_uptime_seconds", "gauge", "Seconds since the server started.", time.Since(s.startTime).Seconds())
+	b.metric("redis_loading", "gauge", "Whether the AOF is being loaded.", float64(boolToInt(s.loading.Load())))
+	b.metric("redis_connected_clients", "gauge", "Number of client connections.", float64(connected))
+	b.metric("redis_connections_received_total", "counter", "Total number of connections accepted.", float64(s.totalConnections.Load()))
+	b.metric("redis_commands_processed_total", "counter", "Total number of commands processed.", float64(s.stats.CommandsProcessed()))
+
+	all := s.stats.AllCommandStats()
+	b.family("redis_commands_total", "counter", "Total number of calls per command.")
+	for _, cs := range all {
+		b.sample("redis_commands_total", float64(cs.Calls), "cmd", cs.Name)
+	}
+	b.family("redis_commands_rejected_calls_total", "counter", "Total number of calls per command refused before running.")
+	for _, cs := range all {
+		b.sample("redis_commands_rejected_calls_total", float64(cs.RejectedCalls), "cmd", cs.Name)
+	}
+	b.family("redis_commands_failed_calls_total", "counter", "Total number of calls per command that replied with an error.")
+	for _, cs := range all {
+		b.sample("redis_commands_failed_calls_total", float64(cs.FailedCalls), "cmd", cs.Name)
+	}
+	b.family("redis_command_duration_seconds", "histogram", "Time spent running each command.")
+	for _, cs := range all {
+		var cumulative int64
+		for i := 0; i < metricsBuckets; i++ {
+			cumulative += cs.Histogram[i]
+			le := (time.Duration(1<<i) * time.Microsecond).Seconds()
+			b.sample("redis_command_duration_seconds_bucket", float64(cumulative), "cmd", cs.Name, "le", strconv.FormatFloat(le, 'g', -1, 64))
+		}
+		b.sample("redis_command_duration_seconds_bucket", float64(cs.Calls), "cmd", cs.Name, "le", "+Inf")
+		b.sample("redis_command_duration_seconds_sum", float64(cs.Usec)/1e6, "cmd", cs.Name)
+		b.sample("redis_command_duration_seconds_count", float64(cs.Calls), "cmd", cs.Name)
+	}
+	errors := s.stats.ErrorStats()
+	b.family("redis_errors_total", "counter", "Total number of error replies per error code.")
+	for _, code := range slices.Sorted(maps.Keys(errors)) {
+		b.sample("redis_errors_total", float64(errors[code]), "code", code)
+	}
+
+	stats := s.Store.Stats()
+	b.family("redis_keys", "gauge", "Number of keys per type of value.")
+	for _, kind := range slices.Sorted(maps.Keys(stats.KeysByType)) {
+		b.sample("redis_keys", float64(stats.KeysByType[kind]), "type", kind)
+	}
+	b.metric("redis_keys_with_expiration", "gauge", "Number of keys with an expiration.", float64(stats.Expires))
+	b.metric("redis_expired_keys_total", "counter", "Total number of keys deleted because they expired.", float64(stats.ExpiredKeys))
+	b.metric("redis_evicted_keys_total", "counter", "Total number of keys evicted by maxmemory.", float64(stats.EvictedKeys))
+	b.metric("redis_keyspace_hits_total", "counter", "Total number of reads of existing keys.", float64(stats.KeyspaceHits))
+	b.metric("redis_keyspace_misses_total", "counter", "Total number of reads of missing keys.", float64(stats.KeyspaceMisses))
+	b.metric("redis_memory_used_bytes", "gauge", "Estimated memory used by the dataset.", float64(stats.UsedMemory))
+	b.metric("redis_memory_max_bytes", "gauge", "The maxmemory limit, 0 if none.", float64(stats.MaxMemory))
+
+	if s.Log != nil {
+		aof := s.Log.Stats()
+		b.metric("redis_aof_current_size_bytes", "gauge", "Size of the AOF.", float64(aof.CurrentSize))
+		b.metric("redis_aof_base_size_bytes", "gauge", "Size of the AOF after the last rewrite or save.", float64(aof.BaseSize))
+		b.metric("redis_aof_rewrite_in_progress", "gauge", "Whether an AOF rewrite is running.", float64(boolToInt(aof.RewriteInProgress)))
+		b.metric("redis_aof_last_write_ok", "gauge", "Whether the last write to the AOF succeeded.", float64(boolToInt(aof.LastWriteOK)))
+		var lastFsync float64
+		if !aof.LastFsync.IsZero() {
+			lastFsync = float64(aof.LastFsync.UnixMilli()) / 1000
+		}
+		b.metric("redis_aof_last_fsync_timestamp_seconds", "gauge", "Unix time of the last fsync of the AOF, 0 if none.", lastFsync)
+		b.metric("redis_rdb_changes_since_last_save", "gauge", "Number of writes since the last snapshot.", float64(aof.ChangesSinceLastSave))
+		b.metric("redis_rdb_last_save_timestamp_seconds", "gauge", "Unix time of the last successful snapshot.", float64(aof.LastSave.Unix()))
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teguhkurnia/redis-like/internal/config"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+func get(t *testing.T, handler http.Handler, path string) (int, string) {
+	r := httptest.NewRecorder()
+	handler.ServeHTTP(r, httptest.NewRequest(http.MethodGet, path, nil))
+	return r.Code, r.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	s, conn, _ := serve(t)
+	c := talk(t, conn)
+	c.call("SET key value")
+	c.call("RPUSH list a b")
+	c.call("GET key")
+	c.call("GET")
+
+	code, body := get(t, s.metricsHandler(), "/metrics")
+	assert.Equal(t, http.StatusOK, code)
+	for _, line := range []string{
+		"# TYPE redis_connected_clients gauge\nredis_connected_clients 1\n",
+		"# TYPE redis_commands_total counter\n",
+		`redis_commands_total{cmd="get"} 1` + "\n",
+		`redis_commands_rejected_calls_total{cmd="get"} 1` + "\n",
+		"# TYPE redis_command_duration_seconds histogram\n",
+		`redis_command_duration_seconds_bucket{cmd="set",le="+Inf"} 1` + "\n",
+		`redis_command_duration_seconds_count{cmd="set"} 1` + "\n",
+		`redis_errors_total{code="ERR"} 1` + "\n",
+		`redis_keys{type="string"} 1` + "\n",
+		`redis_keys{type="list"} 1` + "\n",
+		"redis_expired_keys_total 0\n",
+	} {
+		assert.Contains(t, body, line)
+	}
+	assert.Regexp(t, `redis_command_duration_seconds_bucket\{cmd="set",le="1\.048576"\} 1\n`, body)
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	s := testServer()
+	handler := s.metricsHandler()
+	code, body := get(t, handler, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok\n", body)
+
+	s.loading.Store(true)
+	code, body = get(t, handler, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "loading\n", body)
+	s.loading.Store(false)
+	code, body = get(t, handler, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "starting\n", body)
+}
+
+func TestMetricsListener(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := config.Default()
+	cfg.Bind = "127.0.0.1"
+	cfg.Port = 0
+	cfg.MetricsAddr = "127.0.0.1:0"
+	s := NewServer(cfg, store.NewStore())
+	done := make(chan struct{})
+	go func() {
+		s.Start()
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return s.Addr() != nil }, time.Second, time.Millisecond)
+
+	url := "http://" + s.MetricsAddr().String()
+	resp, err := http.Get(url + "/readyz")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "ok\n", string(body))
+
+	resp, err = http.Get(url + "/metrics")
+	require.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Contains(t, string(body), "redis_aof_current_size_bytes ")
+	assert.Contains(t, string(body), "redis_aof_last_fsync_timestamp_seconds ")
+
+	require.NoError(t, s.Stop(context.Background()))
+	waitDone(t, done)
+	_, err = http.Get(url + "/healthz")
+	assert.Error(t, err)
+}
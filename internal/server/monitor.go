@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/teguhkurnia/redis-like/internal/protocol/commands"
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+// monitorBacklog is how many commands a monitor may fall behind before it
+// is disconnected, so a slow monitor never holds up the clients it watches.
+const monitorBacklog = 1024
+
+// addMonitor starts feeding the commands run by every client to c.
+func (s *Server) addMonitor(c *Client) {
+	s.monitorsMu.Lock()
+	defer s.monitorsMu.Unlock()
+	if s.monitors == nil {
+		s.monitors = make(map[*Client]chan []byte)
+	}
+	s.monitors[c] = make(chan []byte, monitorBacklog)
+	if len(s.monitors) == 1 {
+		s.stats.SetMonitor(s.feedMonitors)
+	}
+}
+
+func (s *Server) removeMonitor(c *Client) {
+	s.monitorsMu.Lock()
+	defer s.monitorsMu.Unlock()
+	delete(s.monitors, c)
+	if len(s.monitors) == 0 {
+		s.stats.SetMonitor(nil)
+	}
+}
+
+// feedMonitors queues cmd to every monitor without waiting. Monitors whose
+// backlog is full are disconnected.
+func (s *Server) feedMonitors(client commands.Client, cmd *commands.Command, start time.Time) {
+	db := 0
+	if c, ok := client.(*Client); ok {
+		db = c.DB()
+	}
+	line := monitorLine(start, db, client.Addr(), cmd)
+
+	s.monitorsMu.Lock()
+	defer s.monitorsMu.Unlock()
+	for m, lines := range s.monitors {
+		select {
+		case lines <- line:
+		default:
+			fmt.Printf("🐌 Disconnecting monitor %s, which fell %d commands behind\n", m.addr, monitorBacklog)
+			delete(s.monitors, m)
+			s.killClient(m, nil)
+		}
+	}
+	if len(s.monitors) == 0 {
+		s.stats.SetMonitor(nil)
+	}
+}
+
+// monitorLine formats cmd the way MONITOR shows it:
+//
+//	+1700000000.123456 [0 127.0.0.1:50000] "SET" "key" "value"
+func monitorLine(start time.Time, db int, addr string, cmd *commands.Command) []byte {
+	b := []byte{'+'}
+	b = strconv.AppendInt(b, start.Unix(), 10)
+	b = fmt.Appendf(b, ".%06d [%d %s]", start.Nanosecond()/1000, db, addr)
+	b = appendRepr(append(b, ' '), []byte(cmd.Name))
+	redact := 0
+	for _, arg := range cmd.Args {
+		switch {
+		case redact > 0:
+			// Monitors do not get to see the credentials of HELLO ... AUTH.
+			arg = []byte("(redacted)")
+			redact--
+		case cmd.Name == "HELLO" && strings.EqualFold(string(arg), "AUTH"):
+			redact = 2
+		}
+		b = appendRepr(append(b, ' '), arg)
+	}
+	return append(b, '\r', '\n')
+}
+
+// appendRepr appends s quoted, with quotes, backslashes and unprintable
+// bytes escaped.
+func appendRepr(b, s []byte) []byte {
+	b = append(b, '"')
+	for _, c := range s {
+		switch c {
+		case '\\', '"':
+			b = append(b, '\\', c)
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case '\t':
+			b = append(b, '\\', 't')
+		case '\a':
+			b = append(b, '\\', 'a')
+		case '\b':
+			b = append(b, '\\', 'b')
+		default:
+			if c < 0x20 || c > 0x7e {
+				b = fmt.Appendf(b, "\\x%02x", c)
+			} else {
+				b = append(b, c)
+			}
+		}
+	}
+	return append(b, '"')
+}
+
+// serveMonitor writes the commands fed to c once it sent MONITOR, until it
+// disconnects or falls behind. Commands it sends afterwards are ignored.
+func (s *Server) serveMonitor(c *Client, out *bufio.Writer) {
+	defer s.removeMonitor(c)
+	s.monitorsMu.Lock()
+	lines, found := s.monitors[c]
+	s.monitorsMu.Unlock()
+	if !found || out.Flush() != nil {
+		return
+	}
+
+	// Reading goes on only to notice when the connection closes.
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, c.conn)
+		close(closed)
+	}()
+	for {
+		select {
+		case line := <-lines:
+			out.Write(line)
+			// Lines that are already queued go out in the same write.
+			for len(lines) > 0 && out.Buffered() < replyBufferSize {
+				out.Write(<-lines)
+			}
+			if out.Flush() != nil {
+				c.conn.Close()
+				<-closed
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// handleMonitor implements MONITOR. readLoop hands the connection over to
+// serveMonitor once the reply is written.
+func handleMonitor(client commands.Client, cmd *commands.Command, store *store.Store, w *resp.Writer) {
+	c := client.(*Client)
+	if c.Flags()&ClientMonitor == 0 {
+		c.SetFlags(ClientMonitor, true)
+		c.srv.addMonitor(c)
+	}
+	w.Status("OK")
+}
+
+// MonitorSpec is the MONITOR command, registered by the server.
+var MonitorSpec = &commands.CommandSpec{
+	Handler:  handleMonitor,
+	Arity:    1,
+	Flags:    []string{"admin", "noscript", "loading", "stale"},
+	FirstKey: 0,
+	LastKey:  0,
+	KeyStep:  0,
+	Documentation: map[string]any{
+		"summary": "Listens for all requests received by the server in real-time.",
+	},
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teguhkurnia/redis-like/internal/protocol/commands"
+)
+
+func TestMonitor(t *testing.T) {
+	s, conn, _ := serve(t)
+	monitor := talk(t, conn)
+	other, _ := connect(t, s)
+	c := talk(t, other)
+
+	assert.Equal(t, "OK", monitor.call("MONITOR").Str)
+	assert.Contains(t, string(c.call("CLIENT LIST ID 1").Bulk), " flags=O ")
+	c.call(`SET key "a b"`)
+	// Admin commands are not shown.
+	c.call("CONFIG GET hz")
+	c.call("GET key")
+	// Refused commands are shown too.
+	c.call("NOSUCHCOMMAND")
+	c.call("GET")
+
+	line, err := monitor.reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Regexp(t, `^\+\d+\.\d{6} \[0 pipe\] "CLIENT" "LIST" "ID" "1"\r\n$`, line)
+	line, err = monitor.reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Regexp(t, `^\+\d+\.\d{6} \[0 pipe\] "SET" "key" "a b"\r\n$`, line)
+	line, err = monitor.reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Regexp(t, `^\+\d+\.\d{6} \[0 pipe\] "GET" "key"\r\n$`, line)
+	line, err = monitor.reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Regexp(t, `^\+\d+\.\d{6} \[0 pipe\] "NOSUCHCOMMAND"\r\n$`, line)
+	line, err = monitor.reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Regexp(t, `^\+\d+\.\d{6} \[0 pipe\] "GET"\r\n$`, line)
+
+	monitor.conn.Close()
+	require.Eventually(t, func() bool {
+		s.monitorsMu.Lock()
+		defer s.monitorsMu.Unlock()
+		return len(s.monitors) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMonitorsStayOnTheirServer(t *testing.T) {
+	a, conn, _ := serve(t)
+	monitorA := talk(t, conn)
+	other, _ := connect(t, a)
+	clientA := talk(t, other)
+	b, conn, _ := serve(t)
+	monitorB := talk(t, conn)
+	other, _ = connect(t, b)
+	clientB := talk(t, other)
+
+	assert.Equal(t, "OK", monitorA.call("MONITOR").Str)
+	assert.Equal(t, "OK", monitorB.call("MONITOR").Str)
+	clientA.call("GET a")
+	clientB.call("GET b")
+	monitorB.conn.Close()
+	require.Eventually(t, func() bool {
+		b.monitorsMu.Lock()
+		defer b.monitorsMu.Unlock()
+		return len(b.monitors) == 0
+	}, time.Second, 10*time.Millisecond)
+	clientA.call("GET c")
+
+	monitorA.conn.SetReadDeadline(time.Now().Add(time.Second))
+	for _, key := range []string{"a", "c"} {
+		line, err := monitorA.reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Regexp(t, `"GET" "`+key+`"\r\n$`, line)
+	}
+}
+
+func TestMonitorDisconnectsSlowMonitors(t *testing.T) {
+	s, conn, done := serve(t)
+	monitor := talk(t, conn)
+	other, _ := connect(t, s)
+	c := talk(t, other)
+	assert.Equal(t, "OK", monitor.call("MONITOR").Str)
+
+	// The monitor reads nothing, so its backlog fills up while the other
+	// client goes on.
+	for i := 0; i < monitorBacklog+10; i++ {
+		c.call("PING")
+	}
+	waitDone(t, done)
+	assert.Equal(t, "PONG", c.call("PING").Str)
+}
+
+func TestMonitorLine(t *testing.T) {
+	start := time.Unix(1700000000, 123456789)
+	line := monitorLine(start, 0, "127.0.0.1:50000", &commands.Command{
+		Name: "SET",
+		Args: [][]byte{[]byte("key"), []byte("\"quoted\"\r\n\x00é")},
+	})
+	assert.Equal(t, `+1700000000.123456 [0 127.0.0.1:50000] "SET" "key" "\"quoted\"\r\n\x00\xc3\xa9"`+"\r\n", string(line))
+
+	line = monitorLine(start, 0, "127.0.0.1:50000", &commands.Command{
+		Name: "HELLO",
+		Args: [][]byte{[]byte("3"), []byte("auth"), []byte("user"), []byte("secret"), []byte("SETNAME"), []byte("worker")},
+	})
+	assert.Equal(t, `+1700000000.123456 [0 127.0.0.1:50000] "HELLO" "3" "auth" "(redacted)" "(redacted)" "SETNAME" "worker"`+"\r\n", string(line))
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pause is the state of CLIENT PAUSE. While it lasts, commands that are
+// paused wait before running, in the order they were sent.
+type pause struct {
+	// started is set from the first pause until UNPAUSE, so commands skip
+	// the lock while no pause is in effect.
+	started atomic.Bool
+
+	mu    sync.Mutex
+	until time.Time
+	// all pauses every command rather than only writes.
+	all bool
+	// resumed is closed by UNPAUSE to wake up waiting clients.
+	resumed chan struct{}
+}
+
+// start pauses commands for d, or only writes when all is false. A pause
+// already in effect only gets longer or stricter.
+func (p *pause) start(d time.Duration, all bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.started.Store(true)
+	until := time.Now().Add(d)
+	if p.resumed == nil || time.Now().After(p.until) {
+		p.until, p.all, p.resumed = until, all, make(chan struct{})
+		return
+	}
+	if until.After(p.until) {
+		p.until = until
+	}
+	p.all = p.all || all
+}
+
+func (p *pause) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumed != nil {
+		close(p.resumed)
+		p.resumed = nil
+	}
+	p.until = time.Time{}
+	p.started.Store(false)
+}
+
+// active reports whether a pause may be in effect.
+func (p *pause) active() bool {
+	return p.started.Load()
+}
+
+// blocks reports whether a command, a write or not, has to wait, and until
+// when at most.
+func (p *pause) blocks(write bool) (time.Time, <-chan struct{}, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumed == nil || !time.Now().Before(p.until) || !(p.all || write) {
+		return time.Time{}, nil, false
+	}
+	return p.until, p.resumed, true
+}
+
+// wait returns once a command, a write or not, may run, or quit is closed.
+func (p *pause) wait(write bool, quit <-chan struct{}) {
+	for {
+		until, resumed, paused := p.blocks(write)
+		if !paused {
+			return
+		}
+		timer := time.NewTimer(time.Until(until))
+		select {
+		case <-timer.C:
+		case <-resumed:
+		case <-quit:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
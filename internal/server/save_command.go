@@ -0,0 +1,86 @@
+package server
+
+import (
+	"github.com/teguhkurnia/redis-like/internal/protocol/commands"
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+// The persistence commands act on the log of the server the client is
+// connected to, so every server in the process keeps its own files.
+
+func handleSave(client commands.Client, cmd *commands.Command, store *store.Store, w *resp.Writer) {
+	if err := client.(*Client).srv.Log.Save(); err != nil {
+		w.Errorf("ERR %s", err)
+		return
+	}
+	w.Status("OK")
+}
+
+func handleBGSave(client commands.Client, cmd *commands.Command, store *store.Store, w *resp.Writer) {
+	if err := client.(*Client).srv.Log.BGSave(); err != nil {
+		w.Errorf("ERR %s", err)
+		return
+	}
+	w.Status("Background saving started")
+}
+
+func handleLastSave(client commands.Client, cmd *commands.Command, store *store.Store, w *resp.Writer) {
+	w.Int(client.(*Client).srv.Log.LastSave().Unix())
+}
+
+func handleBGRewriteAOF(client commands.Client, cmd *commands.Command, store *store.Store, w *resp.Writer) {
+	if err := client.(*Client).srv.Log.BGRewrite(); err != nil {
+		w.Errorf("ERR %s", err)
+		return
+	}
+	w.Status("Background append only file rewriting started")
+}
+
+var SaveSpec = &commands.CommandSpec{
+	Handler:  handleSave,
+	Arity:    1,
+	Flags:    []string{"admin"},
+	FirstKey: 0,
+	LastKey:  0,
+	KeyStep:  0,
+	Documentation: map[string]any{
+		"summary": "Synchronously saves the dataset to disk.",
+	},
+}
+
+var BGSaveSpec = &commands.CommandSpec{
+	Handler:  handleBGSave,
+	Arity:    1,
+	Flags:    []string{"admin"},
+	FirstKey: 0,
+	LastKey:  0,
+	KeyStep:  0,
+	Documentation: map[string]any{
+		"summary": "Asynchronously saves the dataset to disk.",
+	},
+}
+
+var LastSaveSpec = &commands.CommandSpec{
+	Handler:  handleLastSave,
+	Arity:    1,
+	Flags:    []string{"readonly", "fast"},
+	FirstKey: 0,
+	LastKey:  0,
+	KeyStep:  0,
+	Documentation: map[string]any{
+		"summary": "Returns the Unix timestamp of the last successful save to disk.",
+	},
+}
+
+var BGRewriteAOFSpec = &commands.CommandSpec{
+	Handler:  handleBGRewriteAOF,
+	Arity:    1,
+	Flags:    []string{"admin"},
+	FirstKey: 0,
+	LastKey:  0,
+	KeyStep:  0,
+	Documentation: map[string]any{
+		"summary": "Asynchronously rewrites the append-only file to its minimal form.",
+	},
+}
@@ -2,13 +2,25 @@ package server
 
 import (
 	"bufio"
+	"cmp"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/teguhkurnia/redis-like/internal/config"
 	"github.com/teguhkurnia/redis-like/internal/log"
 	"github.com/teguhkurnia/redis-like/internal/protocol"
+	"github.com/teguhkurnia/redis-like/internal/protocol/commands"
 	"github.com/teguhkurnia/redis-like/internal/protocol/parser"
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
 	"github.com/teguhkurnia/redis-like/internal/store"
 )
 
@@ -22,87 +34,337 @@ type Server struct {
 	Store      *store.Store
 	Log        *log.Log
 	ln         net.Listener
-	clients    map[string]net.Conn
 
+	// mu guards ln and clients, which acceptLoop and every readLoop update.
+	mu           sync.Mutex
+	clients      map[int64]*Client
+	nextClientID int64
+	// wg counts the connections being served.
+	wg sync.WaitGroup
+
+	pause pause
+
+	// configMu guards config, which CONFIG reads and replaces.
+	configMu sync.Mutex
+	config   *config.Config
+	// hz is config.Hz, read by expireLoop.
+	hz atomic.Int64
+
+	// stats records the commands run for clients.
+	stats *protocol.Stats
+
+	// startTime and runID identify this run of the server in INFO.
+	startTime time.Time
+	runID     string
+	// loading is set while the AOF is replayed.
+	loading          atomic.Bool
+	totalConnections atomic.Int64
+
+	// monitors holds the queue of commands waiting to be written to each
+	// client that sent MONITOR.
+	monitorsMu sync.Mutex
+	monitors   map[*Client]chan []byte
+
+	// metrics serves metrics over HTTP when metrics-addr is set. mu guards
+	// it along with metricsAddr.
+	metrics     *http.Server
+	metricsAddr net.Addr
+
+	// stopping is set and quitChan closed when the server starts shutting
+	// down. stopped is closed once it is done.
+	stopping atomic.Bool
 	quitChan chan struct{}
+	stopped  chan struct{}
 	msgChan  chan *Message
 }
 
-func NewServer(listenAddr string, store *store.Store) *Server {
-	return &Server{
-		ListenAddr: listenAddr,
+func init() {
+	// Commands bound to a server find it through the client that sent them,
+	// so they are registered once for every server in the process.
+	protocol.RegisterCommand("BGREWRITEAOF", BGRewriteAOFSpec)
+	protocol.RegisterCommand("SAVE", SaveSpec)
+	protocol.RegisterCommand("BGSAVE", BGSaveSpec)
+	protocol.RegisterCommand("LASTSAVE", LastSaveSpec)
+	protocol.RegisterCommand("CLIENT", ClientSpec)
+	protocol.RegisterCommand("SHUTDOWN", ShutdownSpec)
+	protocol.RegisterCommand("CONFIG", ConfigSpec)
+	protocol.RegisterCommand("INFO", InfoSpec)
+	protocol.RegisterCommand("MONITOR", MonitorSpec)
+}
+
+// NewServer returns a server for store, set up with cfg, which the server
+// keeps and updates with CONFIG SET.
+func NewServer(cfg *config.Config, store *store.Store) *Server {
+	s := &Server{
+		ListenAddr: cfg.ListenAddr(),
 		Store:      store,
-		clients:    make(map[string]net.Conn),
+		clients:    make(map[int64]*Client),
 		quitChan:   make(chan struct{}),
+		stopped:    make(chan struct{}),
 		msgChan:    make(chan *Message, 100),
-		Log:        log.NewLog("server.log"),
+		Log:        log.NewLog(cfg.AppendFilename, cfg.DBFilename, cfg.AppendFsync, store),
+		stats:      protocol.NewStats(),
+		startTime:  time.Now(),
+		runID:      newRunID(),
 	}
+	s.applyConfig(cfg)
+
+	return s
 }
 
+// Start loads the AOF and serves connections until the server is stopped,
+// with Stop or SHUTDOWN.
 func (s *Server) Start() {
-	// Initialize the store and log
+	// Metrics are served while the AOF loads, so /readyz can tell.
+	s.loading.Store(true)
+	if addr := s.Config().MetricsAddr; addr != "" {
+		s.startMetrics(addr)
+	}
+
+	// Like in Redis, keys do not expire until the AOF is loaded, so writes
+	// replayed after a key expired find it as clients did.
+	s.Store.SetLoading(true)
 	cmds, err := s.Log.LoadCommandsFromLog()
 	if err != nil {
 		panic(fmt.Sprintf("Failed to load commands from log: %v", err))
 	}
 
+	// Replies to replayed commands are discarded.
+	w := resp.NewWriter(resp.RESP2)
 	for _, cmd := range cmds {
-		protocol.HandleCommand(cmd, s.Store, s.Log, true)
+		protocol.HandleCommand(nil, cmd, s.Store, s.Log, w, true)
+		w.Reset()
 	}
+	w.Release()
+	s.Store.SetLoading(false)
+	s.loading.Store(false)
+	go s.expireLoop()
 
 	ln, err := net.Listen("tcp", s.ListenAddr)
 	if err != nil {
 		panic(err)
 	}
+	s.mu.Lock()
 	s.ln = ln
-	go s.acceptLoop()
+	s.mu.Unlock()
+	if s.stopping.Load() {
+		// Stopped while loading; stop may have missed the listener.
+		ln.Close()
+	}
+	go s.acceptLoop(ln)
 
 	fmt.Printf("🚀 Server started on %s\n", s.ListenAddr)
-	<-s.quitChan
-	s.ln.Close()
-	close(s.msgChan)
-	close(s.quitChan)
+	<-s.stopped
 }
 
-func (s *Server) acceptLoop() {
+// Addr returns the address the server listens on, once it has started.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+// expireCycleBudget is the share of every hz period the active expire cycle
+// may use, 25% like in Redis.
+const expireCycleBudget = 25
+
+// expireLoop runs the active expire cycle hz times per second and logs the
+// deletion of every expired key, so replaying the AOF matches what clients
+// observed.
+func (s *Server) expireLoop() {
+	hz := s.hz.Load()
+	ticker := time.NewTicker(time.Second / time.Duration(hz))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if current := s.hz.Load(); current != hz {
+				hz = current
+				ticker.Reset(time.Second / time.Duration(hz))
+			}
+			// Like in Redis, keys do not expire while clients are paused,
+			// so the dataset stays put for a failover.
+			if _, _, paused := s.pause.blocks(true); paused {
+				continue
+			}
+			budget := time.Second / time.Duration(hz) * expireCycleBudget / 100
+			s.Store.ActiveExpireCycle(budget, func(sample func() []string) {
+				s.Log.Apply(func() []*commands.Command {
+					expired := sample()
+					cmds := make([]*commands.Command, 0, len(expired))
+					for _, key := range expired {
+						cmds = append(cmds, &commands.Command{Name: "DEL", Args: [][]byte{[]byte(key)}})
+					}
+					return cmds
+				})
+			})
+		case <-s.quitChan:
+			return
+		}
+	}
+}
+
+func (s *Server) acceptLoop(ln net.Listener) {
 	for {
-		conn, err := s.ln.Accept()
+		conn, err := ln.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			fmt.Printf("Error accepting connection: %v\n", err)
 			continue
 		}
 		fmt.Printf("💬 New connection from %s\n", conn.RemoteAddr().String())
-		s.clients[conn.RemoteAddr().String()] = conn
-		go s.readLoop(conn)
+		go s.readLoop(s.addClient(conn))
+	}
+}
+
+// addClient registers a new connection. Client ids start at 1 and are never
+// reused, like in Redis.
+func (s *Server) addClient(conn net.Conn) *Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextClientID++
+	c := newClient(s.nextClientID, s, conn)
+	s.clients[c.id] = c
+	s.totalConnections.Add(1)
+	s.wg.Add(1)
+	if s.stopping.Load() {
+		// Accepted as the server stopped: stop serves it no command.
+		conn.SetReadDeadline(time.Now())
 	}
+	return c
 }
 
-func (s *Server) readLoop(conn net.Conn) {
-	reader := bufio.NewReader(conn)
+// clientList returns the connected clients, ordered by id.
+func (s *Server) clientList() []*Client {
+	s.mu.Lock()
+	list := make([]*Client, 0, len(s.clients))
+	for _, c := range s.clients {
+		list = append(list, c)
+	}
+	s.mu.Unlock()
+	slices.SortFunc(list, func(a, b *Client) int {
+		return cmp.Compare(a.id, b.id)
+	})
+	return list
+}
+
+// killClient closes the connection of c, on behalf of self. A client killing
+// itself is closed once its reply has been written.
+func (s *Server) killClient(c, self *Client) {
+	c.SetFlags(ClientCloseASAP, true)
+	if c != self {
+		c.conn.Close()
+	}
+}
+
+// replyBufferSize is how many bytes of replies are held per connection
+// before they are written out, even if more commands are pending.
+const replyBufferSize = 16 * 1024
+
+// flushingReader flushes pending replies before reading from the connection.
+// bufio.Reader only reads once every complete command it holds has been
+// served, so a pipelined batch of commands gets its replies in as few
+// writes as possible, and a client waiting for a reply always gets it.
+type flushingReader struct {
+	conn net.Conn
+	out  *bufio.Writer
+}
+
+func (r *flushingReader) Read(p []byte) (int, error) {
+	if err := r.out.Flush(); err != nil {
+		return 0, err
+	}
+	return r.conn.Read(p)
+}
+
+// readLoop serves one connection until the client disconnects or sends
+// something that is not valid RESP. Protocol errors are reported to the
+// client before closing, as the stream cannot be resynchronized.
+func (s *Server) readLoop(c *Client) {
+	defer s.removeClient(c)
+	out := bufio.NewWriterSize(c.conn, replyBufferSize)
+	reader := bufio.NewReader(&flushingReader{conn: c.conn, out: out})
+	w := c.w
 	for {
-		value, err := parser.ParseNextValue(reader)
-		if err != nil {
+		value, err := parser.ParseRequest(reader)
+		if err == nil && value.Type == '*' && len(value.Array) == 0 {
+			// Redis silently skips empty requests, including blank inline lines.
 			continue
 		}
-		cmd, err := value.ToCommand()
+		var cmd *commands.Command
+		if err == nil {
+			cmd, err = value.ToCommand()
+		}
 		if err != nil {
-			if err == io.EOF {
-				fmt.Printf("❌ Connection closed by %s\n", conn.RemoteAddr().String())
-				conn.Close()
-				delete(s.clients, conn.RemoteAddr().String())
-				return
+			var protoErr *parser.ProtocolError
+			switch {
+			case errors.As(err, &protoErr):
+				w.Errorf("ERR %s", err)
+				out.Write(w.Bytes())
+				out.Flush()
+			case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed), errors.Is(err, os.ErrDeadlineExceeded),
+				errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+			default:
+				fmt.Printf("Error reading from %s: %v\n", c.addr, err)
 			}
+			return
+		}
 
-			continue
+		if s.pause.active() && !s.waitUnpaused(c, cmd, out) {
+			return
+		}
+		if s.stopping.Load() {
+			// Commands pipelined after the shutdown started are dropped.
+			out.Flush()
+			return
 		}
 
-		response := protocol.HandleCommand(cmd, s.Store, s.Log, false)
-		if response != nil {
-			_, err := conn.Write(response)
-			if err != nil {
-				conn.Close()
-				delete(s.clients, conn.RemoteAddr().String())
-				return
-			}
+		c.setLastCommand(cmd.Name, reader.Buffered(), out.Buffered())
+		protocol.HandleCommand(c, cmd, s.Store, s.Log, w, false)
+		// Replies are flushed by flushingReader once no command is pending.
+		_, err = out.Write(w.Bytes())
+		w.Reset()
+		if err != nil {
+			return
+		}
+		if c.Flags()&ClientCloseASAP != 0 {
+			out.Flush()
+			return
 		}
+		if c.Flags()&ClientMonitor != 0 {
+			s.serveMonitor(c, out)
+			return
+		}
+	}
+}
+
+// waitUnpaused holds cmd back while CLIENT PAUSE is in effect for it, and
+// reports whether the connection should go on once it may run.
+func (s *Server) waitUnpaused(c *Client, cmd *commands.Command, out *bufio.Writer) bool {
+	spec, found := protocol.LookupCommand(cmd.Name)
+	write := found && slices.Contains(spec.Flags, "write")
+	if _, _, paused := s.pause.blocks(write); !paused {
+		return true
 	}
+	// Replies to the commands before are not held up by the pause.
+	if out.Flush() != nil {
+		return false
+	}
+	s.pause.wait(write, s.quitChan)
+	return c.Flags()&ClientCloseASAP == 0 && !s.stopping.Load()
+}
+
+func (s *Server) removeClient(c *Client) {
+	c.conn.Close()
+	s.mu.Lock()
+	delete(s.clients, c.id)
+	s.mu.Unlock()
+	c.w.Release()
+	fmt.Printf("❌ Connection closed by %s\n", c.addr)
+	s.wg.Done()
 }
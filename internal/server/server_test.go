@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teguhkurnia/redis-like/internal/config"
+	"github.com/teguhkurnia/redis-like/internal/protocol"
+	"github.com/teguhkurnia/redis-like/internal/protocol/parser"
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+// testServer returns a server without an AOF, which is not listening.
+func testServer() *Server {
+	s := &Server{Store: store.NewStore(), clients: make(map[int64]*Client), stats: protocol.NewStats(), startTime: time.Now(), runID: newRunID()}
+	s.applyConfig(config.Default())
+	return s
+}
+
+// serve runs readLoop on one end of a pipe and returns the other end, along
+// with a channel closed once readLoop returns.
+func serve(t *testing.T) (*Server, net.Conn, chan struct{}) {
+	s := testServer()
+	client, done := connect(t, s)
+	return s, client, done
+}
+
+// connect adds a client to s, like serve.
+func connect(t *testing.T, s *Server) (net.Conn, chan struct{}) {
+	server, client := net.Pipe()
+	c := s.addClient(server)
+	done := make(chan struct{})
+	go func() {
+		s.readLoop(c)
+		close(done)
+	}()
+	t.Cleanup(func() { client.Close() })
+	return client, done
+}
+
+func waitDone(t *testing.T, done chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("readLoop did not return")
+	}
+}
+
+func TestReadLoopProtocolError(t *testing.T) {
+	s, client, done := serve(t)
+	go client.Write([]byte("*1\r\n$abc\r\n"))
+
+	reply, err := bufio.NewReader(client).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "-ERR Protocol error: invalid bulk length\r\n", reply)
+	waitDone(t, done)
+	assert.Empty(t, s.clients)
+}
+
+func TestReadLoopDisconnect(t *testing.T) {
+	s, client, done := serve(t)
+	reader := bufio.NewReader(client)
+	go client.Write([]byte("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n"))
+	reply, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "+OK\r\n", reply)
+
+	// Hanging up in the middle of a request ends the loop right away.
+	client.Write([]byte("*2\r\n$3\r\nGET\r\n"))
+	client.Close()
+	waitDone(t, done)
+	assert.Empty(t, s.clients)
+}
+
+func TestReadLoopTracksClient(t *testing.T) {
+	s, client, _ := serve(t)
+	reader := bufio.NewReader(client)
+	go client.Write([]byte("HELLO 3 SETNAME worker\r\n"))
+	value, err := parser.ParseNextValue(reader)
+	require.NoError(t, err)
+	assert.Equal(t, byte('%'), value.Type)
+
+	s.mu.Lock()
+	c := s.clients[1]
+	s.mu.Unlock()
+	require.NotNil(t, c)
+	assert.Equal(t, "worker", c.Name())
+	assert.Equal(t, resp.RESP3, c.Protocol())
+	name, _ := c.LastCommand()
+	assert.Equal(t, "hello", name)
+
+	// Replies after HELLO use the new protocol.
+	go client.Write([]byte("GET missing\r\n"))
+	reply, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "_\r\n", reply)
+}
+
+func TestReadLoopPipelining(t *testing.T) {
+	_, client, _ := serve(t)
+	var batch bytes.Buffer
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&batch, "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$%d\r\n%d\r\n", len(fmt.Sprint(i)), i)
+		batch.WriteString("*2\r\n$3\r\nGET\r\n$1\r\nk\r\n")
+	}
+	go client.Write(batch.Bytes())
+
+	reader := bufio.NewReader(client)
+	for i := 0; i < 100; i++ {
+		reply, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "+OK\r\n", reply)
+		reply, err = reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf(":%d\r\n", i), reply)
+	}
+}
+
+// listen serves connections on a loopback port, so benchmarks pay for real
+// syscalls.
+func listen(b *testing.B) net.Conn {
+	s := testServer()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(b, err)
+	s.ln = ln
+	go s.acceptLoop(ln)
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(b, err)
+	b.Cleanup(func() {
+		conn.Close()
+		ln.Close()
+	})
+	return conn
+}
+
+const (
+	benchSet = "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"
+	benchGet = "*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n"
+	// benchReplies is what one SET and one GET reply.
+	benchReplies = "+OK\r\n$5\r\nvalue\r\n"
+)
+
+// BenchmarkSetGet sends one command at a time and waits for its reply. An op
+// is one SET and one GET.
+func BenchmarkSetGet(b *testing.B) {
+	conn := listen(b)
+	replies := make([]byte, len(benchReplies))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn.Write([]byte(benchSet))
+		io.ReadFull(conn, replies[:5])
+		conn.Write([]byte(benchGet))
+		io.ReadFull(conn, replies[5:])
+	}
+}
+
+// BenchmarkSetGetPipelined sends SET and GET pairs in batches of 1000
+// commands, reading the replies once the batch is sent.
+func BenchmarkSetGetPipelined(b *testing.B) {
+	const pairs = 500
+	conn := listen(b)
+	batch := []byte(strings.Repeat(benchSet+benchGet, pairs))
+	replies := make([]byte, pairs*len(benchReplies))
+	b.ResetTimer()
+	for i := 0; i < b.N; i += pairs {
+		go conn.Write(batch)
+		if _, err := io.ReadFull(conn, replies); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/teguhkurnia/redis-like/internal/log"
+	"github.com/teguhkurnia/redis-like/internal/protocol/commands"
+	"github.com/teguhkurnia/redis-like/internal/protocol/resp"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+type shutdownOptions struct {
+	// save writes a snapshot before shutting down.
+	save bool
+	// now closes connections without waiting for commands in flight.
+	now bool
+	// force shuts down even if the snapshot cannot be saved.
+	force bool
+}
+
+// Stop shuts the server down like SHUTDOWN without arguments: it saves a
+// snapshot if save rules are configured, stops accepting connections, lets
+// the commands in flight finish and closes every client before syncing the
+// AOF. Connections still busy when ctx is done are closed right away. Start
+// returns once the server has stopped.
+func (s *Server) Stop(ctx context.Context) error {
+	return s.shutdown(ctx, shutdownOptions{save: s.Log != nil && len(s.Log.SaveRules()) > 0})
+}
+
+func (s *Server) shutdown(ctx context.Context, opts shutdownOptions) error {
+	if err := s.prepareShutdown(ctx, opts); err != nil {
+		return err
+	}
+	return s.stop(ctx, opts)
+}
+
+// prepareShutdown saves the snapshot, while clients are still served, so a
+// failed save leaves the server running unless the shutdown is forced.
+func (s *Server) prepareShutdown(ctx context.Context, opts shutdownOptions) error {
+	if !opts.save || s.Log == nil || s.stopping.Load() {
+		return nil
+	}
+	for {
+		err := s.Log.Save()
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, log.ErrSaveInProgress) || errors.Is(err, log.ErrRewriteInProgress) {
+			// Wait for the background job rather than failing the shutdown.
+			select {
+			case <-time.After(100 * time.Millisecond):
+				continue
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+		fmt.Printf("Error saving the dataset before shutdown: %v\n", err)
+		if opts.force {
+			return nil
+		}
+		return err
+	}
+}
+
+// stop closes the listener and every connection, waiting for the commands
+// in flight unless opts.now is set or ctx is done first, then closes the
+// AOF. Calls after the first wait for it to complete.
+func (s *Server) stop(ctx context.Context, opts shutdownOptions) error {
+	if !s.stopping.CompareAndSwap(false, true) {
+		select {
+		case <-s.stopped:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	fmt.Println("🛑 Shutting down")
+	close(s.quitChan)
+
+	// Readers blocked on a connection wake up with a timeout once their
+	// deadline has passed, after the command they are running, if any.
+	s.mu.Lock()
+	if s.ln != nil {
+		s.ln.Close()
+	}
+	for _, c := range s.clients {
+		if opts.now {
+			c.conn.Close()
+		} else {
+			c.conn.SetReadDeadline(time.Now())
+		}
+	}
+	s.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(drained)
+	}()
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.mu.Lock()
+		for _, c := range s.clients {
+			c.conn.Close()
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	if s.metrics != nil {
+		s.metrics.Close()
+	}
+	s.mu.Unlock()
+	if s.Log != nil {
+		if closeErr := s.Log.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	close(s.msgChan)
+	close(s.stopped)
+	fmt.Println("👋 Server stopped")
+	return err
+}
+
+// handleShutdown implements SHUTDOWN. When the shutdown goes ahead the
+// client gets no reply and its connection is closed, like in Redis.
+func handleShutdown(client commands.Client, cmd *commands.Command, store *store.Store, w *resp.Writer) {
+	c := client.(*Client)
+	s := c.srv
+	opts := shutdownOptions{save: s.Log != nil && len(s.Log.SaveRules()) > 0}
+	var save, nosave, abort bool
+	for _, arg := range cmd.Args {
+		switch strings.ToUpper(string(arg)) {
+		case "SAVE":
+			save = true
+		case "NOSAVE":
+			nosave = true
+		case "NOW":
+			opts.now = true
+		case "FORCE":
+			opts.force = true
+		case "ABORT":
+			abort = true
+		default:
+			w.Error("ERR syntax error")
+			return
+		}
+	}
+	if (save && nosave) || (abort && len(cmd.Args) > 1) {
+		w.Error("ERR syntax error")
+		return
+	}
+	if abort {
+		// Shutdowns never wait for replicas, so there is nothing to abort.
+		w.Error("ERR No shutdown in progress.")
+		return
+	}
+	if save {
+		opts.save = true
+	} else if nosave {
+		opts.save = false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config().ShutdownTimeout)
+	if err := s.prepareShutdown(ctx, opts); err != nil {
+		cancel()
+		w.Error("ERR Errors trying to SHUTDOWN. Check logs.")
+		return
+	}
+	c.SetFlags(ClientCloseASAP, true)
+	// The connection sending SHUTDOWN has to return to be drained.
+	go func() {
+		defer cancel()
+		s.stop(ctx, opts)
+	}()
+}
+
+// ShutdownSpec is the SHUTDOWN command, which stops the server of the client
+// that sent it.
+var ShutdownSpec = &commands.CommandSpec{
+	Handler:  handleShutdown,
+	Arity:    -1,
+	Flags:    []string{"admin", "noscript", "loading", "stale"},
+	FirstKey: 0,
+	LastKey:  0,
+	KeyStep:  0,
+	Documentation: map[string]any{
+		"summary": "Synchronously saves the database(s) to disk and shuts down the Redis server.",
+	},
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teguhkurnia/redis-like/internal/config"
+	"github.com/teguhkurnia/redis-like/internal/protocol/commands"
+	"github.com/teguhkurnia/redis-like/internal/snapshot"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+// start runs a server in a temporary directory, where it keeps its AOF and
+// snapshot, and returns it once it listens, with a channel closed once Start
+// returns.
+func start(t *testing.T, dir string) (*Server, chan struct{}) {
+	t.Chdir(dir)
+	cfg := config.Default()
+	cfg.Bind = "127.0.0.1"
+	cfg.Port = 0
+	s := NewServer(cfg, store.NewStore())
+	done := make(chan struct{})
+	go func() {
+		s.Start()
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return s.Addr() != nil }, time.Second, time.Millisecond)
+	return s, done
+}
+
+func dial(t *testing.T, s *Server) *conversation {
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return talk(t, conn)
+}
+
+func TestStop(t *testing.T) {
+	dir := t.TempDir()
+	s, done := start(t, dir)
+	c := dial(t, s)
+	assert.Equal(t, "OK", c.call("SET key value").Str)
+
+	require.NoError(t, s.Stop(context.Background()))
+	waitDone(t, done)
+	_, err := c.reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	_, err = net.Dial("tcp", s.Addr().String())
+	assert.Error(t, err)
+	// Stopping again is a no-op.
+	require.NoError(t, s.Stop(context.Background()))
+
+	// The default save rules make Stop save a snapshot.
+	assert.FileExists(t, "dump.rdb")
+	s, _ = start(t, dir)
+	defer s.Stop(context.Background())
+	value, _, _ := s.Store.Get("key")
+	assert.Equal(t, "value", value)
+}
+
+func TestStopDropsPausedCommands(t *testing.T) {
+	s, done := start(t, t.TempDir())
+	admin := dial(t, s)
+	c := dial(t, s)
+	assert.Equal(t, "OK", admin.call("CLIENT PAUSE 10000 WRITE").Str)
+	c.send("SET key value")
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.Stop(ctx))
+	waitDone(t, done)
+	assert.False(t, s.Store.Exists("key"))
+}
+
+func TestShutdownCommand(t *testing.T) {
+	dir := t.TempDir()
+	s, done := start(t, dir)
+	c := dial(t, s)
+	assert.Equal(t, "ERR syntax error", c.call("SHUTDOWN SAVE NOSAVE").Str)
+	assert.Equal(t, "ERR syntax error", c.call("SHUTDOWN ABORT NOW").Str)
+	assert.Equal(t, "ERR No shutdown in progress.", c.call("SHUTDOWN ABORT").Str)
+	assert.Equal(t, "OK", c.call("SET key value").Str)
+
+	// A successful SHUTDOWN gets no reply.
+	c.send("SHUTDOWN NOSAVE")
+	_, err := c.reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	waitDone(t, done)
+
+	// The write is recovered from the AOF alone.
+	_, err = os.Stat("dump.rdb")
+	assert.True(t, os.IsNotExist(err))
+	s, _ = start(t, dir)
+	defer s.Stop(context.Background())
+	value, _, _ := s.Store.Get("key")
+	assert.Equal(t, "value", value)
+}
+
+func TestServersKeepTheirOwnLog(t *testing.T) {
+	// Both servers run from one directory, so their files are named apart.
+	t.Chdir(t.TempDir())
+	servers := make([]*Server, 2)
+	for i := range servers {
+		cfg := config.Default()
+		cfg.Bind = "127.0.0.1"
+		cfg.Port = 0
+		cfg.AppendFilename = fmt.Sprintf("server%d.log", i)
+		cfg.DBFilename = fmt.Sprintf("dump%d.rdb", i)
+		servers[i] = NewServer(cfg, store.NewStore())
+		go servers[i].Start()
+		defer servers[i].Stop(context.Background())
+		require.Eventually(t, func() bool { return servers[i].Addr() != nil }, time.Second, time.Millisecond)
+	}
+
+	c := dial(t, servers[0])
+	assert.Equal(t, "OK", c.call("SET key value").Str)
+	assert.Equal(t, "OK", c.call("SAVE").Str)
+	assert.FileExists(t, "dump0.rdb")
+	assert.NoFileExists(t, "dump1.rdb")
+}
+
+func TestReplayDoesNotResurrectExpiredKeys(t *testing.T) {
+	dir := t.TempDir()
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, snapshot.Write(filepath.Join(dir, "dump.rdb"), &snapshot.Snapshot{
+		ID:        "base",
+		CreatedAt: past,
+		Data:      map[string]store.Data{"saved": {Value: []string{"a"}, TTL: past.UnixMilli()}},
+	}))
+	aof := []byte("REDIS-LIKE-AOF 1 base=base\r\n")
+	for _, args := range [][]string{
+		{"SET", "string", "1", "PXAT", strconv.FormatInt(past.UnixMilli(), 10)},
+		{"INCR", "string"},
+		{"RPUSH", "list", "a"},
+		{"PEXPIREAT", "list", strconv.FormatInt(past.UnixMilli(), 10)},
+		{"RPUSH", "list", "b"},
+		{"RPUSH", "saved", "b"},
+	} {
+		cmd := &commands.Command{Name: args[0]}
+		for _, arg := range args[1:] {
+			cmd.Args = append(cmd.Args, []byte(arg))
+		}
+		entry, err := cmd.ToLog()
+		require.NoError(t, err)
+		aof = append(aof, entry...)
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "server.log"), aof, 0o644))
+
+	s, _ := start(t, dir)
+	defer s.Stop(context.Background())
+	c := dial(t, s)
+	for _, key := range []string{"string", "list", "saved"} {
+		assert.Equal(t, int64(-2), c.call("PTTL "+key).Int, key)
+	}
+	// Expiration is back on once the AOF is loaded.
+	assert.Equal(t, "OK", c.call("SET key value PX 1").Str)
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, int64(-2), c.call("PTTL key").Int)
+}
@@ -0,0 +1,402 @@
+package snapshot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+// A snapshot file is laid out as:
+//
+//	magic "RLSNAP" | version byte | id string | created-at int64 (unix ms)
+//	entries: type byte | key string | expire-at int64 (unix ms, 0 = none) | value
+//	typeEOF | crc32 of everything before it
+//
+// Strings are a uvarint length followed by the raw bytes, and collections a
+// uvarint count followed by their elements.
+const (
+	magic          = "RLSNAP"
+	currentVersion = 1
+)
+
+const (
+	typeString byte = iota
+	typeList
+	typeHash
+	typeSet
+	typeSortedSet
+	typeEOF byte = 0xff
+)
+
+// maxStringLen matches the largest bulk string Redis accepts by default.
+const maxStringLen = 512 * 1024 * 1024
+
+var ErrCorrupt = errors.New("snapshot file is corrupt")
+
+type Snapshot struct {
+	ID        string
+	CreatedAt time.Time
+	Data      map[string]store.Data
+}
+
+// Write encodes the snapshot into a new file at path and fsyncs it. Callers
+// rename the file into place once it is safe to do so.
+func Write(path string, snap *Snapshot) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := encode(file, snap); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
+// Load reads the snapshot at path. Keys that expired while the server was
+// down are kept with their TTL, as the AOF based on the snapshot may write
+// them again; the store expires them once loading is done.
+func Load(path string) (*Snapshot, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := newReader(file)
+	snap, err := r.header()
+	if err != nil {
+		return nil, err
+	}
+
+	snap.Data = make(map[string]store.Data)
+	for {
+		valueType, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if valueType == typeEOF {
+			break
+		}
+		key, err := r.string()
+		if err != nil {
+			return nil, err
+		}
+		expireAt, err := r.int64()
+		if err != nil {
+			return nil, err
+		}
+		value, err := r.value(valueType)
+		if err != nil {
+			return nil, err
+		}
+		snap.Data[key] = store.Data{Value: value, TTL: expireAt}
+	}
+
+	sum := r.crc.Sum32()
+	var stored uint32
+	if err := binary.Read(r.br, binary.LittleEndian, &stored); err != nil {
+		return nil, ErrCorrupt
+	}
+	if stored != sum {
+		return nil, ErrCorrupt
+	}
+	return snap, nil
+}
+
+// ReadID returns the id of the snapshot at path without loading its data.
+func ReadID(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	snap, err := newReader(file).header()
+	if err != nil {
+		return "", err
+	}
+	return snap.ID, nil
+}
+
+func encode(file io.Writer, snap *Snapshot) error {
+	bw := bufio.NewWriter(file)
+	crc := crc32.NewIEEE()
+	w := &writer{w: io.MultiWriter(bw, crc)}
+
+	w.raw([]byte(magic))
+	w.raw([]byte{currentVersion})
+	w.string(snap.ID)
+	w.int64(snap.CreatedAt.UnixMilli())
+
+	for key, data := range snap.Data {
+		valueType, ok := typeOf(data.Value)
+		if !ok {
+			return fmt.Errorf("unsupported value type %T for key %q", data.Value, key)
+		}
+		w.raw([]byte{valueType})
+		w.string(key)
+		w.int64(data.TTL)
+		w.value(data.Value)
+	}
+	w.raw([]byte{typeEOF})
+	if w.err != nil {
+		return w.err
+	}
+
+	if err := binary.Write(bw, binary.LittleEndian, crc.Sum32()); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func typeOf(value any) (byte, bool) {
+	switch value.(type) {
+	case string:
+		return typeString, true
+	case []string:
+		return typeList, true
+	case map[string]string:
+		return typeHash, true
+	case map[string]struct{}:
+		return typeSet, true
+	case []store.SortedSet:
+		return typeSortedSet, true
+	}
+	return 0, false
+}
+
+// writer records the first error and ignores every write after it, which
+// keeps the encoder free of per-field error checks.
+type writer struct {
+	w   io.Writer
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (w *writer) raw(p []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(p)
+	}
+}
+
+func (w *writer) uvarint(n uint64) {
+	w.raw(w.buf[:binary.PutUvarint(w.buf[:], n)])
+}
+
+func (w *writer) int64(n int64) {
+	binary.LittleEndian.PutUint64(w.buf[:8], uint64(n))
+	w.raw(w.buf[:8])
+}
+
+func (w *writer) string(s string) {
+	w.uvarint(uint64(len(s)))
+	w.raw([]byte(s))
+}
+
+func (w *writer) value(value any) {
+	switch v := value.(type) {
+	case string:
+		w.string(v)
+	case []string:
+		w.uvarint(uint64(len(v)))
+		for _, item := range v {
+			w.string(item)
+		}
+	case map[string]string:
+		w.uvarint(uint64(len(v)))
+		for field, val := range v {
+			w.string(field)
+			w.string(val)
+		}
+	case map[string]struct{}:
+		w.uvarint(uint64(len(v)))
+		for member := range v {
+			w.string(member)
+		}
+	case []store.SortedSet:
+		w.uvarint(uint64(len(v)))
+		for _, member := range v {
+			w.int64(int64(math.Float64bits(member.Score)))
+			w.string(member.Member)
+		}
+	}
+}
+
+type reader struct {
+	br  *bufio.Reader
+	r   io.Reader
+	crc hash.Hash32
+}
+
+func newReader(file io.Reader) *reader {
+	br := bufio.NewReader(file)
+	crc := crc32.NewIEEE()
+	return &reader{br: br, r: io.TeeReader(br, crc), crc: crc}
+}
+
+func (r *reader) header() (*Snapshot, error) {
+	head := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(r.r, head); err != nil || string(head[:len(magic)]) != magic {
+		return nil, fmt.Errorf("not a snapshot file")
+	}
+	if version := head[len(magic)]; version > currentVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	id, err := r.string()
+	if err != nil {
+		return nil, err
+	}
+	createdAt, err := r.int64()
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{ID: id, CreatedAt: time.UnixMilli(createdAt)}, nil
+}
+
+func (r *reader) byte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r.r, b[:]); err != nil {
+		return 0, ErrCorrupt
+	}
+	return b[0], nil
+}
+
+func (r *reader) uvarint() (uint64, error) {
+	n, err := binary.ReadUvarint(byteReader{r})
+	if err != nil {
+		return 0, ErrCorrupt
+	}
+	return n, nil
+}
+
+func (r *reader) int64() (int64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r.r, b[:]); err != nil {
+		return 0, ErrCorrupt
+	}
+	return int64(binary.LittleEndian.Uint64(b[:])), nil
+}
+
+func (r *reader) string() (string, error) {
+	n, err := r.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if n > maxStringLen {
+		return "", ErrCorrupt
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return "", ErrCorrupt
+	}
+	return string(b), nil
+}
+
+// count reads a collection length, guarding against corrupt files that
+// would otherwise make us allocate huge slices up front.
+func (r *reader) count() (int, error) {
+	n, err := r.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt32 {
+		return 0, ErrCorrupt
+	}
+	return int(n), nil
+}
+
+func (r *reader) value(valueType byte) (any, error) {
+	switch valueType {
+	case typeString:
+		return r.string()
+	case typeList:
+		n, err := r.count()
+		if err != nil {
+			return nil, err
+		}
+		list := make([]string, 0, min(n, 1024))
+		for i := 0; i < n; i++ {
+			item, err := r.string()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, nil
+	case typeHash:
+		n, err := r.count()
+		if err != nil {
+			return nil, err
+		}
+		hash := make(map[string]string, min(n, 1024))
+		for i := 0; i < n; i++ {
+			field, err := r.string()
+			if err != nil {
+				return nil, err
+			}
+			value, err := r.string()
+			if err != nil {
+				return nil, err
+			}
+			hash[field] = value
+		}
+		return hash, nil
+	case typeSet:
+		n, err := r.count()
+		if err != nil {
+			return nil, err
+		}
+		set := make(map[string]struct{}, min(n, 1024))
+		for i := 0; i < n; i++ {
+			member, err := r.string()
+			if err != nil {
+				return nil, err
+			}
+			set[member] = struct{}{}
+		}
+		return set, nil
+	case typeSortedSet:
+		n, err := r.count()
+		if err != nil {
+			return nil, err
+		}
+		zset := make([]store.SortedSet, 0, min(n, 1024))
+		for i := 0; i < n; i++ {
+			bits, err := r.int64()
+			if err != nil {
+				return nil, err
+			}
+			member, err := r.string()
+			if err != nil {
+				return nil, err
+			}
+			zset = append(zset, store.SortedSet{Score: math.Float64frombits(uint64(bits)), Member: member})
+		}
+		return zset, nil
+	}
+	return nil, ErrCorrupt
+}
+
+type byteReader struct {
+	r *reader
+}
+
+func (b byteReader) ReadByte() (byte, error) {
+	return b.r.byte()
+}
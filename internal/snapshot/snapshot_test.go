@@ -0,0 +1,56 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teguhkurnia/redis-like/internal/store"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	expireAt := time.Now().Add(time.Hour).UnixMilli()
+	data := map[string]store.Data{
+		"string":  {Value: "hello world\r\n\x00"},
+		"counter": {Value: "42"},
+		"list":    {Value: []string{"a", "b b", ""}},
+		"hash":    {Value: map[string]string{"field": "value"}},
+		"set":     {Value: map[string]struct{}{"x": {}, "y": {}}},
+		"zset":    {Value: []store.SortedSet{{Score: -1.5, Member: "low"}, {Score: 2, Member: "high"}}},
+		"ttl":     {Value: "soon", TTL: expireAt},
+		"expired": {Value: "gone", TTL: time.Now().Add(-time.Hour).UnixMilli()},
+	}
+	require.NoError(t, Write(path, &Snapshot{ID: "abc", CreatedAt: time.Now(), Data: data}))
+
+	id, err := ReadID(path)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", id)
+
+	snap, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", snap.ID)
+	assert.Equal(t, data["expired"], snap.Data["expired"])
+	assert.Equal(t, "42", snap.Data["counter"].Value)
+	assert.Equal(t, expireAt, snap.Data["ttl"].TTL)
+	for _, key := range []string{"string", "list", "hash", "set", "zset"} {
+		assert.Equal(t, data[key], snap.Data[key], key)
+	}
+}
+
+func TestSnapshotDetectsCorruption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	data := map[string]store.Data{"key": {Value: "value"}}
+	require.NoError(t, Write(path, &Snapshot{ID: "abc", CreatedAt: time.Now(), Data: data}))
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	raw[len(raw)-6] ^= 0xff
+	require.NoError(t, os.WriteFile(path, raw, 0644))
+
+	_, err = Load(path)
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
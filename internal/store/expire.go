@@ -0,0 +1,152 @@
+package store
+
+import (
+	"time"
+)
+
+const (
+	// expireSampleSize is how many keys with a TTL one step of the active
+	// expire cycle looks at, like ACTIVE_EXPIRE_CYCLE_KEYS_PER_LOOP in Redis.
+	expireSampleSize = 20
+	// expireAcceptableStale is the percentage of expired keys in a sample
+	// below which the cycle stops, assuming few expired keys are left.
+	expireAcceptableStale = 25
+	// staleQueueSize bounds the expired keys found by reads that are
+	// waiting to be deleted.
+	staleQueueSize = 1024
+)
+
+// lookup returns the entry for key unless it is missing or expired, and
+// counts the keyspace hit or miss.
+func (s *Store) lookup(key string) (Data, bool) {
+	data, exists := s.peek(key)
+	if exists {
+		s.keyspaceHits.Add(1)
+	} else {
+		s.keyspaceMisses.Add(1)
+	}
+	return data, exists
+}
+
+// peek is lookup without the keyspace stats. It only needs the read lock,
+// so expired entries it comes across are queued for the next active expire
+// cycle to delete.
+func (s *Store) peek(key string) (Data, bool) {
+	data, exists := s.data[key]
+	if !exists {
+		return Data{}, false
+	}
+	now := time.Now().UnixMilli()
+	if s.expired(data, now) {
+		select {
+		case s.stale <- key:
+		default:
+		}
+		return Data{}, false
+	}
+	data.meta.touch(now, s.policy.lfu())
+	return data, true
+}
+
+// lookupWrite is lookup for callers holding the write lock, which delete
+// expired entries right away. The deleted keys are kept for TakeLazyExpired.
+func (s *Store) lookupWrite(key string) (Data, bool) {
+	data, exists := s.data[key]
+	if !exists {
+		return Data{}, false
+	}
+	now := time.Now().UnixMilli()
+	if s.expired(data, now) {
+		s.remove(key)
+		s.expiredKeys++
+		s.lazyExpired = append(s.lazyExpired, key)
+		return Data{}, false
+	}
+	data.meta.touch(now, s.policy.lfu())
+	return data, true
+}
+
+// TakeLazyExpired returns the expired keys that writes deleted since the
+// last call. Like Redis, which propagates a DEL for every key it expires,
+// their deletion must be logged ahead of the write that found them:
+// nothing expires while the AOF loads, so the write would otherwise be
+// replayed against the old value.
+func (s *Store) TakeLazyExpired() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := s.lazyExpired
+	s.lazyExpired = nil
+	return keys
+}
+
+// ActiveExpireCycle deletes expired keys without scanning the whole
+// keyspace, following Redis: it samples keys that have a TTL and samples
+// again while more than a quarter of the sample had expired, until budget
+// runs out. Each sample is taken inside step, so callers can log the
+// deleted keys atomically with their removal.
+func (s *Store) ActiveExpireCycle(budget time.Duration, step func(sample func() []string)) {
+	start := time.Now()
+	var sampled, expired int
+	for {
+		var n, e int
+		step(func() []string {
+			var keys []string
+			keys, n, e = s.expireSample()
+			return keys
+		})
+		sampled += n
+		expired += e
+		if n == 0 || e*100 <= n*expireAcceptableStale {
+			break
+		}
+		if time.Since(start) >= budget {
+			s.mu.Lock()
+			s.expiredTimeCapReached++
+			s.mu.Unlock()
+			break
+		}
+	}
+
+	current := 0.0
+	if sampled > 0 {
+		current = float64(expired) * 100 / float64(sampled)
+	}
+	s.mu.Lock()
+	s.expiredStalePerc = current*0.05 + s.expiredStalePerc*0.95
+	s.mu.Unlock()
+}
+
+// expireSample deletes the expired keys queued by reads, then samples up to
+// expireSampleSize keys with a TTL and deletes those that expired. It
+// returns every deleted key along with the sample size and how many in the
+// sample had expired.
+func (s *Store) expireSample() (deleted []string, sampled, expired int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UnixMilli()
+
+drain:
+	for i := 0; i < staleQueueSize; i++ {
+		select {
+		case key := <-s.stale:
+			if data, ok := s.data[key]; ok && data.expired(now) {
+				s.remove(key)
+				s.expiredKeys++
+				deleted = append(deleted, key)
+			}
+		default:
+			break drain
+		}
+	}
+
+	for ; sampled < expireSampleSize && s.expires.len() > 0; sampled++ {
+		key := s.expires.random()
+		if s.data[key].expired(now) {
+			s.remove(key)
+			s.expiredKeys++
+			deleted = append(deleted, key)
+			expired++
+		}
+	}
+	return deleted, sampled, expired
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActiveExpireCycle(t *testing.T) {
+	s := NewStore()
+	past := time.Now().Add(-time.Second).UnixMilli()
+	future := time.Now().Add(time.Hour).UnixMilli()
+	data := make(map[string]Data)
+	for i := 0; i < 1000; i++ {
+		data[fmt.Sprintf("expired:%d", i)] = Data{Value: "v", TTL: past}
+	}
+	for i := 0; i < 100; i++ {
+		data[fmt.Sprintf("live:%d", i)] = Data{Value: "v", TTL: future}
+		data[fmt.Sprintf("persistent:%d", i)] = Data{Value: "v"}
+	}
+	s.Restore(data)
+
+	var deleted []string
+	s.ActiveExpireCycle(time.Second, func(sample func() []string) {
+		deleted = append(deleted, sample()...)
+	})
+
+	// The cycle keeps sampling while most sampled keys are expired, so it
+	// gets through most of them in a single run.
+	assert.Greater(t, len(deleted), 500)
+	for i := 0; i < 1000 && s.Stats().ExpiredKeys < 1000; i++ {
+		s.ActiveExpireCycle(time.Second, func(sample func() []string) {
+			deleted = append(deleted, sample()...)
+		})
+	}
+
+	stats := s.Stats()
+	assert.Equal(t, int64(1000), stats.ExpiredKeys)
+	assert.Len(t, deleted, 1000)
+	assert.Equal(t, 200, stats.Keys)
+	assert.Equal(t, 100, stats.Expires)
+	for _, key := range deleted {
+		assert.Contains(t, key, "expired:")
+	}
+}
+
+func TestActiveExpireCycleTimeCap(t *testing.T) {
+	s := NewStore()
+	past := time.Now().Add(-time.Second).UnixMilli()
+	data := make(map[string]Data)
+	for i := 0; i < 1000; i++ {
+		data[fmt.Sprintf("expired:%d", i)] = Data{Value: "v", TTL: past}
+	}
+	s.Restore(data)
+
+	steps := 0
+	s.ActiveExpireCycle(0, func(sample func() []string) {
+		steps++
+		sample()
+	})
+	assert.Equal(t, 1, steps)
+	assert.Equal(t, int64(1), s.Stats().ExpiredTimeCapReachedCount)
+}
+
+func TestLazyExpiry(t *testing.T) {
+	s := NewStore()
+	past := time.Now().Add(-time.Second).UnixMilli()
+	s.Restore(map[string]Data{
+		"string": {Value: "v", TTL: past},
+		"list":   {Value: []string{"a"}, TTL: past},
+		"hash":   {Value: map[string]string{"f": "v"}, TTL: past},
+		"set":    {Value: map[string]struct{}{"m": {}}, TTL: past},
+		"zset":   {Value: []SortedSet{{Score: 1, Member: "m"}}, TTL: past},
+	})
+
+	assert.False(t, s.Exists("string"))
+	_, found, _ := s.Get("string")
+	assert.False(t, found)
+	list, _ := s.LRange("list", 0, -1)
+	assert.Empty(t, list)
+	_, found, _ = s.HGet("hash", "f")
+	assert.False(t, found)
+	members, _ := s.SMembers("set")
+	assert.Empty(t, members)
+	found, _ = s.SIsMember("set", "m")
+	assert.False(t, found)
+	zset, _ := s.ZRange("zset", 0, -1)
+	assert.Empty(t, zset)
+	assert.Equal(t, int64(-2), s.PTTL("list"))
+
+	// Reads only queue what they find; the next cycle deletes it without
+	// having to sample for it.
+	deleted, sampled, _ := s.expireSample()
+	assert.ElementsMatch(t, []string{"string", "list", "hash", "set", "zset"}, deleted)
+	assert.Equal(t, 0, sampled)
+
+	// Writes delete expired keys themselves and start from scratch.
+	s.Restore(map[string]Data{"list": {Value: []string{"a"}, TTL: past}})
+	length, err := s.RPush("list", []string{"b"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, length)
+	assert.Equal(t, int64(-1), s.PTTL("list"))
+	assert.Equal(t, 0, s.Stats().Expires)
+}
+
+func TestNoExpiryWhileLoading(t *testing.T) {
+	s := NewStore()
+	past := time.Now().Add(-time.Second)
+	s.SetLoading(true)
+	_, err := s.SetWithOptions("string", "1", SetOptions{ExpireAt: past})
+	assert.NoError(t, err)
+	_, err = s.Incr("string")
+	assert.NoError(t, err)
+	s.RPush("list", []string{"a"})
+	assert.Equal(t, 1, s.ExpireAt("list", past, ExpireAlways))
+	length, _ := s.RPush("list", []string{"b"})
+	assert.Equal(t, 2, length)
+	value, _, _ := s.Get("string")
+	assert.Equal(t, "2", value)
+
+	s.SetLoading(false)
+	assert.False(t, s.Exists("string"))
+	assert.False(t, s.Exists("list"))
+	deleted, _, _ := s.expireSample()
+	assert.ElementsMatch(t, []string{"string", "list"}, deleted)
+}
+
+func TestKeyspaceHitsAndMisses(t *testing.T) {
+	s := NewStore()
+	s.Restore(map[string]Data{"expired": {Value: "v", TTL: time.Now().Add(-time.Second).UnixMilli()}})
+	s.Set("key", "value")
+
+	s.Get("key")
+	s.Get("missing")
+	s.Get("expired")
+	stats := s.Stats()
+	assert.Equal(t, int64(1), stats.KeyspaceHits)
+	assert.Equal(t, int64(2), stats.KeyspaceMisses)
+
+	s.ResetStats()
+	assert.Zero(t, s.Stats().KeyspaceHits)
+	assert.Zero(t, s.Stats().KeyspaceMisses)
+}
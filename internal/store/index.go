@@ -0,0 +1,43 @@
+package store
+
+import "math/rand/v2"
+
+// keyIndex holds a set of keys in a slice, so the active expire cycle and
+// eviction can pick them uniformly at random. Sampling by ranging over a map
+// keeps landing next to the keys deleted by earlier samples.
+type keyIndex struct {
+	keys []string
+	pos  map[string]int
+}
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{pos: make(map[string]int)}
+}
+
+func (x *keyIndex) len() int {
+	return len(x.keys)
+}
+
+func (x *keyIndex) add(key string) {
+	if _, ok := x.pos[key]; ok {
+		return
+	}
+	x.pos[key] = len(x.keys)
+	x.keys = append(x.keys, key)
+}
+
+func (x *keyIndex) remove(key string) {
+	i, ok := x.pos[key]
+	if !ok {
+		return
+	}
+	last := len(x.keys) - 1
+	x.keys[i] = x.keys[last]
+	x.pos[x.keys[i]] = i
+	x.keys = x.keys[:last]
+	delete(x.pos, key)
+}
+
+func (x *keyIndex) random() string {
+	return x.keys[rand.IntN(len(x.keys))]
+}
@@ -0,0 +1,287 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrOOM is returned by Evict when the store is over its memory limit and
+// the eviction policy cannot free enough memory.
+var ErrOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+// EvictionPolicy selects which keys are evicted once the store is over its
+// memory limit, mirroring the maxmemory-policy setting of Redis.
+type EvictionPolicy int
+
+const (
+	// NoEviction never evicts and rejects commands that need more memory.
+	NoEviction EvictionPolicy = iota
+	AllKeysLRU
+	AllKeysLFU
+	AllKeysRandom
+	VolatileLRU
+	VolatileLFU
+	VolatileRandom
+	// VolatileTTL evicts the keys closest to expiring first.
+	VolatileTTL
+)
+
+var evictionPolicyNames = map[EvictionPolicy]string{
+	NoEviction:     "noeviction",
+	AllKeysLRU:     "allkeys-lru",
+	AllKeysLFU:     "allkeys-lfu",
+	AllKeysRandom:  "allkeys-random",
+	VolatileLRU:    "volatile-lru",
+	VolatileLFU:    "volatile-lfu",
+	VolatileRandom: "volatile-random",
+	VolatileTTL:    "volatile-ttl",
+}
+
+func ParseEvictionPolicy(s string) (EvictionPolicy, error) {
+	for policy, name := range evictionPolicyNames {
+		if strings.EqualFold(s, name) {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid maxmemory policy: %s", s)
+}
+
+func (p EvictionPolicy) String() string {
+	if name, ok := evictionPolicyNames[p]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// volatile reports whether the policy only evicts keys that have a TTL.
+func (p EvictionPolicy) volatile() bool {
+	return p == VolatileLRU || p == VolatileLFU || p == VolatileRandom || p == VolatileTTL
+}
+
+func (p EvictionPolicy) lfu() bool {
+	return p == AllKeysLFU || p == VolatileLFU
+}
+
+const (
+	// evictionSamples is how many keys are compared to pick each key to
+	// evict, like the default maxmemory-samples of Redis.
+	evictionSamples = 5
+
+	// The LFU counter is logarithmic and decays over time, with the
+	// defaults of Redis: new keys start at lfuInitVal so they are not
+	// evicted right away, lfuLogFactor controls how many hits it takes to
+	// saturate the counter, and it drops by one for every lfuDecayTime the
+	// key goes untouched.
+	lfuInitVal   = 5
+	lfuLogFactor = 10
+	lfuDecayTime = time.Minute
+)
+
+// entryMeta tracks how a key is accessed for the LRU and LFU policies. It is
+// updated with atomics, so reads can touch keys under the read lock.
+type entryMeta struct {
+	// atime is the last access time in Unix milliseconds.
+	atime atomic.Int64
+	// freq is the logarithmic LFU access counter, at most 255.
+	freq atomic.Uint32
+}
+
+func newEntryMeta(now int64) *entryMeta {
+	meta := &entryMeta{}
+	meta.atime.Store(now)
+	meta.freq.Store(lfuInitVal)
+	return meta
+}
+
+// touch records an access, bumping the LFU counter only when the policy
+// needs it since that costs a random number per access.
+func (m *entryMeta) touch(now int64, lfu bool) {
+	if lfu {
+		freq := m.decayedFreq(now)
+		if freq < math.MaxUint8 {
+			base := max(float64(freq)-lfuInitVal, 0)
+			if rand.Float64() < 1/(base*lfuLogFactor+1) {
+				freq++
+			}
+		}
+		m.freq.Store(freq)
+	}
+	m.atime.Store(now)
+}
+
+func (m *entryMeta) decayedFreq(now int64) uint32 {
+	freq := m.freq.Load()
+	periods := uint32((now - m.atime.Load()) / lfuDecayTime.Milliseconds())
+	if periods >= freq {
+		return 0
+	}
+	return freq - periods
+}
+
+// Overheads approximate what the Go runtime spends on top of the raw bytes:
+// map slots, slice and string headers and the entry's bookkeeping.
+const (
+	entryOverhead   = 96
+	stringOverhead  = 16
+	elementOverhead = 16
+	mapOverhead     = 48
+	mapSlotOverhead = 32
+)
+
+// sizeOf estimates the memory used by key and its value.
+func sizeOf(key string, value any) int64 {
+	size := entryOverhead + len(key)
+	switch v := value.(type) {
+	case string:
+		size += stringOverhead + len(v)
+	case int:
+		size += 8
+	case []string:
+		size += stringOverhead
+		for _, item := range v {
+			size += elementOverhead + len(item)
+		}
+	case map[string]string:
+		size += mapOverhead
+		for field, val := range v {
+			size += mapSlotOverhead + len(field) + len(val)
+		}
+	case map[string]struct{}:
+		size += mapOverhead
+		for member := range v {
+			size += mapSlotOverhead + len(member)
+		}
+	case []SortedSet:
+		size += stringOverhead
+		for _, member := range v {
+			size += elementOverhead + 8 + len(member.Member)
+		}
+	}
+	return int64(size)
+}
+
+// put stores data under key, keeping the memory accounting and the key
+// indexes in sync. The key's access history carries over when it is
+// overwritten.
+func (s *Store) put(key string, data Data) {
+	now := time.Now().UnixMilli()
+	old, exists := s.data[key]
+	if exists {
+		s.used -= old.size
+		s.typeCounts[typeOf(old.Value)]--
+		if data.meta == nil {
+			data.meta = old.meta
+		}
+	} else {
+		s.keys.add(key)
+	}
+	if data.meta == nil {
+		data.meta = newEntryMeta(now)
+	} else {
+		data.meta.touch(now, s.policy.lfu())
+	}
+	data.size = sizeOf(key, data.Value)
+	s.used += data.size
+	s.peakUsed = max(s.peakUsed, s.used)
+	s.typeCounts[typeOf(data.Value)]++
+
+	s.data[key] = data
+	if data.TTL > 0 {
+		s.expires.add(key)
+	} else {
+		s.expires.remove(key)
+	}
+}
+
+func (s *Store) remove(key string) {
+	if data, exists := s.data[key]; exists {
+		s.used -= data.size
+		s.typeCounts[typeOf(data.Value)]--
+	}
+	delete(s.data, key)
+	s.keys.remove(key)
+	s.expires.remove(key)
+}
+
+// SetMaxMemory sets the memory limit in bytes, 0 meaning none, and the
+// policy used to stay under it.
+func (s *Store) SetMaxMemory(limit int64, policy EvictionPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxMemory = limit
+	s.policy = policy
+}
+
+// Evict frees memory according to the eviction policy until the store is
+// back under its limit, and returns the keys it evicted. It returns ErrOOM
+// when the policy has nothing left to evict.
+func (s *Store) Evict() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var evicted []string
+	for s.maxMemory > 0 && s.used > s.maxMemory {
+		key, ok := s.evictionCandidate()
+		if !ok {
+			return evicted, ErrOOM
+		}
+		s.remove(key)
+		s.evictedKeys++
+		evicted = append(evicted, key)
+	}
+	return evicted, nil
+}
+
+// evictionCandidate approximates the policy the way Redis does: rather than
+// keeping every key ordered by access, it samples a few keys and picks the
+// best one among them. Pools no larger than a sample are compared in full.
+func (s *Store) evictionCandidate() (string, bool) {
+	if s.policy == NoEviction {
+		return "", false
+	}
+	pool := s.keys
+	if s.policy.volatile() {
+		pool = s.expires
+	}
+	if pool.len() == 0 {
+		return "", false
+	}
+	if s.policy == AllKeysRandom || s.policy == VolatileRandom {
+		return pool.random(), true
+	}
+
+	sample := pool.keys
+	if len(sample) > evictionSamples {
+		sample = make([]string, evictionSamples)
+		for i := range sample {
+			sample[i] = pool.random()
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	var best string
+	bestScore := int64(-1)
+	for _, key := range sample {
+		if score := s.evictionScore(key, now); score > bestScore {
+			best, bestScore = key, score
+		}
+	}
+	return best, true
+}
+
+// evictionScore ranks key for eviction, higher scores going first.
+func (s *Store) evictionScore(key string, now int64) int64 {
+	data := s.data[key]
+	switch s.policy {
+	case AllKeysLFU, VolatileLFU:
+		return math.MaxUint8 - int64(data.meta.decayedFreq(now))
+	case VolatileTTL:
+		return math.MaxInt64 - data.TTL
+	}
+	// LRU: the longest idle key goes first.
+	return now - data.meta.atime.Load()
+}
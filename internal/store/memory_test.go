@@ -0,0 +1,145 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEvictionPolicy(t *testing.T) {
+	for policy, name := range evictionPolicyNames {
+		parsed, err := ParseEvictionPolicy(name)
+		require.NoError(t, err)
+		assert.Equal(t, policy, parsed)
+		assert.Equal(t, name, policy.String())
+	}
+	_, err := ParseEvictionPolicy("allkeys-fifo")
+	assert.Error(t, err)
+}
+
+func TestMemoryAccounting(t *testing.T) {
+	s := NewStore()
+	s.Set("key", "value")
+	s.HSet("hash", "field", "value")
+	used := s.Stats().UsedMemory
+	assert.Equal(t, sizeOf("key", "value")+sizeOf("hash", map[string]string{"field": "value"}), used)
+
+	s.HSet("hash", "other", "value")
+	assert.Greater(t, s.Stats().UsedMemory, used)
+
+	s.Del("key")
+	s.HDel("hash", "field")
+	s.HDel("hash", "other")
+	assert.Equal(t, int64(0), s.Stats().UsedMemory)
+	// The peak stays until the stats are reset.
+	assert.Greater(t, s.Stats().UsedMemoryPeak, used)
+	s.ResetStats()
+	assert.Equal(t, int64(0), s.Stats().UsedMemoryPeak)
+}
+
+func TestEvictNoEviction(t *testing.T) {
+	s := NewStore()
+	s.Set("key", "value")
+	s.SetMaxMemory(1, NoEviction)
+
+	evicted, err := s.Evict()
+	assert.ErrorIs(t, err, ErrOOM)
+	assert.Empty(t, evicted)
+	assert.True(t, s.Exists("key"))
+}
+
+func TestEvictPolicies(t *testing.T) {
+	fill := func(policy EvictionPolicy) *Store {
+		s := NewStore()
+		for i := 0; i < 100; i++ {
+			s.Set(fmt.Sprintf("key:%d", i), "value")
+		}
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("key:%d", i)
+			s.ExpireAt(key, time.Now().Add(time.Duration(i+1)*time.Hour), ExpireAlways)
+		}
+		s.SetMaxMemory(s.Stats().UsedMemory/2, policy)
+		return s
+	}
+
+	for _, policy := range []EvictionPolicy{AllKeysLRU, AllKeysLFU, AllKeysRandom} {
+		s := fill(policy)
+		evicted, err := s.Evict()
+		require.NoError(t, err, policy)
+		// Keys differ slightly in size, so about half of them go.
+		assert.InDelta(t, 50, len(evicted), 2, policy)
+		stats := s.Stats()
+		assert.LessOrEqual(t, stats.UsedMemory, stats.MaxMemory, policy)
+		assert.Equal(t, int64(len(evicted)), stats.EvictedKeys, policy)
+	}
+
+	// Volatile policies only ever evict keys with a TTL, and give up once
+	// there are none left.
+	for _, policy := range []EvictionPolicy{VolatileLRU, VolatileLFU, VolatileRandom, VolatileTTL} {
+		s := fill(policy)
+		evicted, err := s.Evict()
+		assert.ErrorIs(t, err, ErrOOM, policy)
+		assert.Len(t, evicted, 10, policy)
+		assert.Equal(t, 0, s.Stats().Expires, policy)
+	}
+}
+
+func TestEvictVolatileTTLPrefersSoonestDeadline(t *testing.T) {
+	s := NewStore()
+	s.Set("persistent", "value")
+	s.Set("soon", "value")
+	s.Set("later", "value")
+	s.ExpireAt("soon", time.Now().Add(time.Minute), ExpireAlways)
+	s.ExpireAt("later", time.Now().Add(time.Hour), ExpireAlways)
+	s.SetMaxMemory(s.Stats().UsedMemory-1, VolatileTTL)
+
+	evicted, err := s.Evict()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"soon"}, evicted)
+}
+
+func TestEvictLRUPrefersIdleKeys(t *testing.T) {
+	s := NewStore()
+	s.SetMaxMemory(0, AllKeysLRU)
+	s.Set("idle", "value")
+	s.Set("busy", "value")
+	s.data["idle"].meta.atime.Store(time.Now().Add(-time.Hour).UnixMilli())
+	s.Get("busy")
+
+	s.SetMaxMemory(s.Stats().UsedMemory-1, AllKeysLRU)
+	evicted, err := s.Evict()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"idle"}, evicted)
+}
+
+func TestLFUCounter(t *testing.T) {
+	meta := newEntryMeta(0)
+	for i := 0; i < 10000; i++ {
+		meta.touch(0, true)
+	}
+	freq := meta.decayedFreq(0)
+	assert.Greater(t, freq, uint32(lfuInitVal))
+	assert.LessOrEqual(t, freq, uint32(255))
+
+	// The counter drops by one per idle decay period.
+	later := 3 * lfuDecayTime.Milliseconds()
+	assert.Equal(t, freq-3, meta.decayedFreq(later))
+}
+
+func TestKeysByType(t *testing.T) {
+	s := NewStore()
+	s.Set("string", "value")
+	s.RPush("list", []string{"a"})
+	s.HSet("hash", "field", "value")
+	s.SAdd("set", []string{"a"})
+	s.ZAdd("zset", []SortedSet{{Score: 1, Member: "a"}})
+	s.Set("list", "now a string")
+	assert.Equal(t, map[string]int{"string": 2, "list": 0, "hash": 1, "set": 1, "zset": 1}, s.Stats().KeysByType)
+
+	s.Del("string")
+	s.HDel("hash", "field")
+	assert.Equal(t, map[string]int{"string": 1, "list": 0, "hash": 0, "set": 1, "zset": 1}, s.Stats().KeysByType)
+}
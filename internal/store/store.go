@@ -1,356 +1,563 @@
 package store
 
 import (
-	"fmt"
+	"errors"
+	"math"
 	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Data struct {
 	Value any
-	TTL   int64
+	// TTL is the absolute expiration time in Unix milliseconds, or 0 when
+	// the key does not expire.
+	TTL int64
+
+	// size is the estimated memory used by the entry, and meta its access
+	// history. Both are maintained by put.
+	size int64
+	meta *entryMeta
+}
+
+// expired reports whether the entry's deadline has passed at now (Unix ms).
+func (d Data) expired(now int64) bool {
+	return d.TTL > 0 && d.TTL <= now
+}
+
+// expired is data.expired, except that nothing expires while the store is
+// loading, like in Redis. A key that expired while the server was down may
+// still be written by the commands logged after it, and must come back with
+// its TTL rather than be created anew without one.
+func (s *Store) expired(data Data, now int64) bool {
+	return !s.loading && data.expired(now)
+}
+
+// SetLoading turns expiration off while the AOF or its base snapshot is
+// loaded, and back on once it is done.
+func (s *Store) SetLoading(loading bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loading = loading
+}
+
+// ExpireCondition restricts when an expiration is applied, mirroring the
+// NX, XX, GT and LT options of the Redis EXPIRE family.
+type ExpireCondition int
+
+const (
+	ExpireAlways ExpireCondition = iota
+	// ExpireNX only sets an expiration when the key has none.
+	ExpireNX
+	// ExpireXX only sets an expiration when the key already has one.
+	ExpireXX
+	// ExpireGT only sets an expiration greater than the current one.
+	ExpireGT
+	// ExpireLT only sets an expiration less than the current one.
+	ExpireLT
+)
+
+// Errors carry the Redis error code as their first word, so the command
+// layer can reply with them as they are.
+var (
+	// ErrWrongType is returned when an operation is applied to a key
+	// holding a different kind of value.
+	ErrWrongType  = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrNotInteger = errors.New("ERR value is not an integer or out of range")
+	ErrOverflow   = errors.New("ERR increment or decrement would overflow")
+)
+
+// SetCondition restricts when SetWithOptions writes the key.
+type SetCondition int
+
+const (
+	SetAlways SetCondition = iota
+	// SetNX only sets the key if it does not exist.
+	SetNX
+	// SetXX only sets the key if it already exists.
+	SetXX
+)
+
+// SetOptions mirrors the options of the Redis SET command.
+type SetOptions struct {
+	Condition SetCondition
+	// ExpireAt, when not zero, becomes the key's expiration time.
+	ExpireAt time.Time
+	// KeepTTL retains the current expiration instead of clearing it.
+	KeepTTL bool
+	// Get requests the previous value, which must be a string.
+	Get bool
+}
+
+type SetResult struct {
+	// Applied reports whether the value was written.
+	Applied bool
+	// Old holds the previous value when Existed is true and Get was set.
+	Old     string
+	Existed bool
 }
 
 type Store struct {
 	mu   sync.RWMutex
 	data map[string]Data
+	// keys indexes every key and expires the keys that have a TTL, so the
+	// active expire cycle and eviction can sample them.
+	keys    *keyIndex
+	expires *keyIndex
+	// stale queues expired keys found under the read lock for deletion.
+	stale chan string
+	// lazyExpired holds the expired keys writes deleted, until taken.
+	lazyExpired []string
+
+	// used is the estimated memory used by all entries, kept under
+	// maxMemory by evicting keys according to policy.
+	used      int64
+	peakUsed  int64
+	maxMemory int64
+	policy    EvictionPolicy
+	// typeCounts counts the keys holding each type of value.
+	typeCounts [len(typeNames)]int
+
+	evictedKeys           int64
+	expiredKeys           int64
+	expiredStalePerc      float64
+	expiredTimeCapReached int64
+	// loading is set while the AOF or its base snapshot is loaded, when no
+	// key expires.
+	loading bool
+
+	// keyspaceHits and keyspaceMisses count lookups, which only hold the
+	// read lock.
+	keyspaceHits   atomic.Int64
+	keyspaceMisses atomic.Int64
 }
 
 func NewStore() *Store {
 	return &Store{
-		data: make(map[string]Data),
+		data:    make(map[string]Data),
+		keys:    newKeyIndex(),
+		expires: newKeyIndex(),
+		stale:   make(chan string, staleQueueSize),
 	}
 }
 
+// Set stores value under key, replacing any previous value and expiration.
 func (s *Store) Set(key, value string) {
+	s.SetWithOptions(key, value, SetOptions{})
+}
+
+// SetWithOptions applies SET with its NX/XX, expiration, KEEPTTL and GET
+// options under a single lock acquisition.
+func (s *Store) SetWithOptions(key, value string, opts SetOptions) (SetResult, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.data[key] = Data{
-		Value: value,
-		TTL:   0,
-	}
-}
+	current, exists := s.lookupWrite(key)
 
-func (s *Store) Get(key string) (string, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	value, exists := s.data[key]
+	var result SetResult
+	if exists && opts.Get {
+		old, ok := current.Value.(string)
+		if !ok {
+			return result, ErrWrongType
+		}
+		result.Old = old
+		result.Existed = true
+	}
+	if (opts.Condition == SetNX && exists) || (opts.Condition == SetXX && !exists) {
+		return result, nil
+	}
 
-	if !exists || (value.TTL > 0 && value.TTL < time.Now().Unix()) {
-		return "", false
+	data := Data{Value: value}
+	switch {
+	case !opts.ExpireAt.IsZero():
+		data.TTL = opts.ExpireAt.UnixMilli()
+	case opts.KeepTTL && exists:
+		data.TTL = current.TTL
 	}
-	if value.TTL > 0 && value.TTL < time.Now().Unix() {
-		s.Del(key) // remove expired key
-		return "", false
+	if s.expired(data, time.Now().UnixMilli()) {
+		s.remove(key)
+	} else {
+		s.put(key, data)
 	}
+	result.Applied = true
+	return result, nil
+}
 
-	if _, ok := value.Value.(string); !ok {
-		return "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", false // value is not a string
+// valueAs returns the value held by data as a T, or ErrWrongType when the
+// key holds another kind of value. Missing keys yield the zero T.
+func valueAs[T any](data Data, exists bool) (T, error) {
+	var zero T
+	if !exists {
+		return zero, nil
 	}
+	value, ok := data.Value.(T)
+	if !ok {
+		return zero, ErrWrongType
+	}
+	return value, nil
+}
 
-	return value.Value.(string), exists
+func (s *Store) Get(key string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, exists := s.lookup(key)
+	value, err := valueAs[string](data, exists)
+	return value, exists && err == nil, err
 }
 
 func (s *Store) Del(key string) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if _, exists := s.data[key]; !exists {
+	if _, exists := s.lookupWrite(key); !exists {
 		return false
 	}
 
-	delete(s.data, key)
+	s.remove(key)
 	return true
 }
 
 func (s *Store) Exists(key string) bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	_, exists := s.data[key]
+	_, exists := s.lookup(key)
 	return exists
 }
 
-func (s *Store) Incr(key string) (int, bool) {
+func (s *Store) Incr(key string) (int64, error) {
+	return s.incrBy(key, 1)
+}
+
+func (s *Store) Decr(key string) (int64, error) {
+	return s.incrBy(key, -1)
+}
+
+// incrBy adds delta to the integer stored as a string at key, keeping its
+// TTL. Missing keys count as 0.
+func (s *Store) incrBy(key string, delta int64) (int64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	value, exists := s.data[key]
-	if !exists {
-		value = Data{
-			Value: "0",
-			TTL:   0,
+	data, exists := s.lookupWrite(key)
+	value, err := valueAs[string](data, exists)
+	if err != nil {
+		return 0, err
+	}
+
+	current := int64(0)
+	if exists {
+		current, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
 		}
 	}
-	// Increment the value
-	intValue, err := strconv.Atoi(fmt.Sprintf("%v", value.Value))
-	if err != nil {
-		return 0, false // Value is not an integer
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, ErrOverflow
 	}
-	value.Value = intValue + 1
-	s.data[key] = value
+	current += delta
+	data.Value = strconv.FormatInt(current, 10)
+	s.put(key, data)
 
-	return intValue + 1, true
+	return current, nil
 }
 
-func (s *Store) Decr(key string) (int, bool) {
+func (s *Store) Expire(key string, seconds int) int {
+	return s.ExpireAt(key, time.Now().Add(time.Duration(seconds)*time.Second), ExpireAlways)
+}
+
+// ExpireAt sets an absolute expiration time on key if cond allows it and
+// returns 1 when it did. Times in the past delete the key right away,
+// unless the store is loading.
+func (s *Store) ExpireAt(key string, at time.Time, cond ExpireCondition) int {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	value, exists := s.data[key]
+	value, exists := s.lookupWrite(key)
 	if !exists {
-		value = Data{
-			Value: "0",
-			TTL:   0,
+		return 0
+	}
+
+	deadline := at.UnixMilli()
+	switch cond {
+	case ExpireNX:
+		if value.TTL > 0 {
+			return 0
+		}
+	case ExpireXX:
+		if value.TTL == 0 {
+			return 0
+		}
+	case ExpireGT:
+		// A key without an expiration never expires, which is greater
+		// than any deadline.
+		if value.TTL == 0 || deadline <= value.TTL {
+			return 0
+		}
+	case ExpireLT:
+		if value.TTL > 0 && deadline >= value.TTL {
+			return 0
 		}
 	}
 
-	intValue, err := strconv.Atoi(fmt.Sprintf("%v", value.Value))
-	if err != nil {
-		return 0, false // Value is not an integer
+	if deadline <= time.Now().UnixMilli() && !s.loading {
+		s.remove(key)
+		return 1
 	}
-	value.Value = intValue - 1
-	s.data[key] = value
+	// While loading, deadlines in the past are kept for the key to expire
+	// once loading is done. A TTL of 0 would mean none.
+	value.TTL = max(deadline, 1)
+	s.put(key, value)
 
-	return intValue - 1, true
+	return 1
 }
 
-func (s *Store) Expire(key string, seconds int) int {
+// Persist removes the expiration from key and returns 1 if it had one.
+func (s *Store) Persist(key string) int {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if _, exists := s.data[key]; !exists {
+	value, exists := s.lookupWrite(key)
+	if !exists || value.TTL == 0 {
 		return 0
-	} else {
-		value := s.data[key]
-		value.TTL = time.Now().Unix() + int64(seconds)
-		s.data[key] = value
 	}
-
+	value.TTL = 0
+	s.put(key, value)
 	return 1
 }
 
-func (s *Store) TTL(key string) int {
+// ExpireTime returns the expiration time of key, or the zero time if it has
+// none. The second value reports whether the key exists. Commands call it
+// to propagate writes, so it does not count as a keyspace hit or miss.
+func (s *Store) ExpireTime(key string) (time.Time, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	if data, exists := s.data[key]; exists {
-		if data.TTL <= 0 {
+	data, exists := s.peek(key)
+	if !exists {
+		return time.Time{}, false
+	}
+	if data.TTL == 0 {
+		return time.Time{}, true
+	}
+	return time.UnixMilli(data.TTL), true
+}
+
+// PTTL returns the remaining time to live of key in milliseconds, -1 if it
+// has no expiration and -2 if it does not exist.
+func (s *Store) PTTL(key string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if data, exists := s.lookup(key); exists {
+		if data.TTL == 0 {
 			return -1 // No expiration set
 		}
-		ttl := data.TTL - time.Now().Unix()
-		if ttl < 0 {
-			return -2 // Key has expired
-		}
-		return int(ttl)
+		return data.TTL - time.Now().UnixMilli()
 	}
 	return -2 // Key does not exist
 }
 
+// TTL is PTTL rounded to the nearest second.
+func (s *Store) TTL(key string) int {
+	ttl := s.PTTL(key)
+	if ttl < 0 {
+		return int(ttl)
+	}
+	return int((ttl + 500) / 1000)
+}
+
 // LIST
-func (s *Store) LPush(key string, values []string) int {
+func (s *Store) LPush(key string, values []string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	currentValues := s.lrangeInternal(key, 0, -1)
-	if currentValues == nil {
-		currentValues = []string{}
-	}
-	// reverse the values to maintain order
-	for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
-		values[i], values[j] = values[j], values[i]
+	data, exists := s.lookupWrite(key)
+	list, err := valueAs[[]string](data, exists)
+	if err != nil {
+		return 0, err
 	}
 
-	s.data[key] = Data{
-		Value: append(values, currentValues...),
-		TTL:   0,
+	pushed := make([]string, 0, len(values)+len(list))
+	for i := len(values) - 1; i >= 0; i-- {
+		pushed = append(pushed, values[i])
 	}
-
-	return len(s.data[key].Value.([]string))
+	data.Value = append(pushed, list...)
+	s.put(key, data)
+	return len(pushed) + len(list), nil
 }
 
-func (s *Store) RPush(key string, values []string) int {
+func (s *Store) RPush(key string, values []string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	currentValues := s.lrangeInternal(key, 0, -1)
-	if currentValues == nil {
-		currentValues = []string{}
-	}
-	s.data[key] = Data{
-		Value: append(currentValues, values...),
-		TTL:   0,
-	}
-	return len(s.data[key].Value.([]string))
-}
-
-func (s *Store) lrangeInternal(key string, start, end int) []string {
-	entry, exists := s.data[key]
-	if !exists {
-		return nil
+	data, exists := s.lookupWrite(key)
+	list, err := valueAs[[]string](data, exists)
+	if err != nil {
+		return 0, err
 	}
 
-	list := entry.Value.([]string)
-	listLen := len(list)
+	// Copy rather than append in place: slices handed out by LPOP and RPOP
+	// may still share the old backing array.
+	pushed := make([]string, 0, len(list)+len(values))
+	pushed = append(append(pushed, list...), values...)
+	data.Value = pushed
+	s.put(key, data)
+	return len(pushed), nil
+}
 
-	// handle the negative indices
+// rangeBounds resolves start and end, which may be negative to count from
+// the end, into slice bounds for a sequence of length n. The range is empty
+// when lo >= hi.
+func rangeBounds(start, end, n int) (lo, hi int) {
 	if start < 0 {
-		start = listLen + start
+		start = n + start
 	}
 	if end < 0 {
-		end = listLen + end
+		end = n + end
 	}
-
-	// Konversi [][]byte ke []string untuk dikembalikan
-	results := make([]string, 0, end-start+1)
-	for _, v := range list[start : end+1] {
-		results = append(results, string(v))
+	start = max(start, 0)
+	end = min(end, n-1)
+	if start > end {
+		return 0, 0
 	}
-	return results
+	return start, end + 1
 }
 
-func (s *Store) LRange(key string, start, end int) []string {
+func (s *Store) LRange(key string, start, end int) ([]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-
-	return s.lrangeInternal(key, start, end)
-}
-
-func (s *Store) LPop(key string, count int) []string {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if _, exists := s.data[key]; !exists {
-		return nil
-	}
-
-	values := s.data[key].Value.([]string)
-	if len(values) == 0 {
-		return nil
+	data, exists := s.lookup(key)
+	list, err := valueAs[[]string](data, exists)
+	if err != nil {
+		return nil, err
 	}
 
-	if count > len(values) {
-		count = len(values)
-	}
+	lo, hi := rangeBounds(start, end, len(list))
+	return append([]string(nil), list[lo:hi]...), nil
+}
 
-	poppedValues := values[:count]
-	newData := s.data[key]
-	newData.Value = values[count:]
-	if len(newData.Value.([]string)) == 0 {
-		delete(s.data, key) // remove the key if no values left
-	} else {
-		s.data[key] = newData
-	}
+func (s *Store) LPop(key string, count int) ([]string, error) {
+	return s.pop(key, count, true)
+}
 
-	return poppedValues
+func (s *Store) RPop(key string, count int) ([]string, error) {
+	return s.pop(key, count, false)
 }
 
-func (s *Store) RPop(key string, count int) []string {
+// pop removes up to count elements from the head or the tail of a list,
+// deleting the key once the list is empty.
+func (s *Store) pop(key string, count int, head bool) ([]string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-
-	if _, exists := s.data[key]; !exists {
-		return nil
+	data, exists := s.lookupWrite(key)
+	values, err := valueAs[[]string](data, exists)
+	if err != nil || len(values) == 0 || count <= 0 {
+		return nil, err
 	}
 
-	values := s.data[key].Value.([]string)
-	if len(values) == 0 {
-		return nil
-	}
-	if count > len(values) {
-		count = len(values)
+	count = min(count, len(values))
+	var popped []string
+	if head {
+		popped = values[:count]
+		data.Value = values[count:]
+	} else {
+		popped = values[len(values)-count:]
+		data.Value = values[:len(values)-count]
 	}
-	poppedValues := values[len(values)-count:]
-	newData := s.data[key]
-	newData.Value = values[:len(values)-count]
-	if len(newData.Value.([]string)) == 0 {
-		delete(s.data, key) // remove the key if no values left
+	if len(data.Value.([]string)) == 0 {
+		s.remove(key) // remove the key if no values left
 	} else {
-		s.data[key] = newData
+		s.put(key, data)
 	}
-	return poppedValues
+	return popped, nil
 }
 
-func (s *Store) LLen(key string) (int, bool) {
+func (s *Store) LLen(key string) (int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-
-	if _, exists := s.data[key]; !exists {
-		return 0, true
-	}
-
-	values, ok := s.data[key].Value.([]string)
-	if !ok {
-		return 0, false
-	}
-	return len(values), true
+	data, exists := s.lookup(key)
+	values, err := valueAs[[]string](data, exists)
+	return len(values), err
 }
 
 // HASH
-func (s *Store) HSet(key, field, value string) int {
+
+// HSet sets field in the hash at key and returns 1 if the field is new.
+func (s *Store) HSet(key, field, value string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	hash, exists := s.data[key]
-	if !exists {
-		hash = Data{
-			Value: make(map[string]string),
-			TTL:   0,
-		}
+	data, exists := s.lookupWrite(key)
+	hash, err := valueAs[map[string]string](data, exists)
+	if err != nil {
+		return 0, err
 	}
-	hash.Value.(map[string]string)[field] = value
-	s.data[key] = hash
-	return 1
+	if hash == nil {
+		hash = make(map[string]string)
+		data.Value = hash
+	}
+
+	_, existed := hash[field]
+	hash[field] = value
+	s.put(key, data)
+	if existed {
+		return 0, nil
+	}
+	return 1, nil
 }
 
-func (s *Store) HGet(key, field string) (string, bool) {
+func (s *Store) HGet(key, field string) (string, bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	hash, exists := s.data[key]
-	if !exists {
-		return "", false
-	}
-	value, ok := hash.Value.(map[string]string)[field]
-	if !ok {
-		return "", false
+	data, exists := s.lookup(key)
+	hash, err := valueAs[map[string]string](data, exists)
+	if err != nil {
+		return "", false, err
 	}
-	return value, true
+	value, ok := hash[field]
+	return value, ok, nil
 }
 
-func (s *Store) HGetAll(key string) (map[string]string, bool) {
+// HGetAll returns a copy of the hash at key, which is empty if the key does
+// not exist.
+func (s *Store) HGetAll(key string) (map[string]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	hash, exists := s.data[key]
-	if !exists {
-		return nil, false
+	data, exists := s.lookup(key)
+	hash, err := valueAs[map[string]string](data, exists)
+	if err != nil {
+		return nil, err
 	}
-	return hash.Value.(map[string]string), true
+	return copyValue(hash).(map[string]string), nil
 }
 
-func (s *Store) HDel(key, field string) int {
+func (s *Store) HDel(key, field string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	hash, exists := s.data[key]
-	if !exists {
-		return 0
+	data, exists := s.lookupWrite(key)
+	hash, err := valueAs[map[string]string](data, exists)
+	if err != nil {
+		return 0, err
 	}
-	if _, ok := hash.Value.(map[string]string)[field]; !ok {
-		return 0
+	if _, ok := hash[field]; !ok {
+		return 0, nil
 	}
-	delete(hash.Value.(map[string]string), field)
-	if len(hash.Value.(map[string]string)) == 0 {
-		delete(s.data, key) // remove the key if no fields left
+	delete(hash, field)
+	if len(hash) == 0 {
+		s.remove(key) // remove the key if no fields left
 	} else {
-		s.data[key] = hash
+		s.put(key, data)
 	}
-	return 1
+	return 1, nil
 }
 
 // SET
-func (s *Store) SAdd(key string, members []string) int {
+func (s *Store) SAdd(key string, members []string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if _, exists := s.data[key]; !exists {
-		s.data[key] = Data{
-			Value: make(map[string]struct{}),
-			TTL:   0,
-		}
+	data, exists := s.lookupWrite(key)
+	set, err := valueAs[map[string]struct{}](data, exists)
+	if err != nil {
+		return 0, err
+	}
+	if set == nil {
+		set = make(map[string]struct{})
+		data.Value = set
 	}
-	set := s.data[key].Value.(map[string]struct{})
+
 	count := 0
 	for _, member := range members {
 		if _, ok := set[member]; !ok {
@@ -358,64 +565,64 @@ func (s *Store) SAdd(key string, members []string) int {
 			count++
 		}
 	}
-	s.data[key] = Data{
-		Value: set,
-		TTL:   0,
-	}
-	return count
+	s.put(key, data)
+	return count, nil
 }
 
-func (s *Store) SRem(key string, members []string) int {
+func (s *Store) SRem(key string, members []string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	set, exists := s.data[key]
-	if !exists {
-		return 0
+	data, exists := s.lookupWrite(key)
+	set, err := valueAs[map[string]struct{}](data, exists)
+	if err != nil || set == nil {
+		return 0, err
 	}
 
 	count := 0
 	for _, member := range members {
-		if _, ok := set.Value.(map[string]struct{})[member]; ok {
-			delete(set.Value.(map[string]struct{}), member)
+		if _, ok := set[member]; ok {
+			delete(set, member)
 			count++
 		}
 	}
 
-	if len(set.Value.(map[string]struct{})) == 0 {
-		delete(s.data, key) // remove the key if no members left
+	if len(set) == 0 {
+		s.remove(key) // remove the key if no members left
 	} else {
-		s.data[key] = set
+		s.put(key, data)
 	}
 
-	return count
+	return count, nil
 }
 
-func (s *Store) SMembers(key string) []string {
+func (s *Store) SMembers(key string) ([]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	set, exists := s.data[key]
-	if !exists {
-		return []string{}
+	data, exists := s.lookup(key)
+	set, err := valueAs[map[string]struct{}](data, exists)
+	if err != nil {
+		return nil, err
 	}
 
-	members := make([]string, 0, len(set.Value.(map[string]struct{})))
-	for member := range set.Value.(map[string]struct{}) {
+	members := make([]string, 0, len(set))
+	for member := range set {
 		members = append(members, member)
 	}
 
-	return members
+	return members, nil
 }
 
-func (s *Store) SIsMember(key, member string) bool {
+func (s *Store) SIsMember(key, member string) (bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	set, exists := s.data[key]
-	if !exists {
-		return false
+	data, exists := s.lookup(key)
+	set, err := valueAs[map[string]struct{}](data, exists)
+	if err != nil {
+		return false, err
 	}
 
-	_, ok := set.Value.(map[string]struct{})[member]
-	return ok
+	_, ok := set[member]
+	return ok, nil
 }
 
 type SortedSet struct {
@@ -424,19 +631,16 @@ type SortedSet struct {
 }
 
 // Sorted Set
-func (s *Store) ZAdd(key string, members []SortedSet) int {
+func (s *Store) ZAdd(key string, members []SortedSet) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	data, exists := s.data[key]
-	if !exists {
-		data = Data{
-			Value: make([]SortedSet, 0),
-			TTL:   0,
-		}
+	data, exists := s.lookupWrite(key)
+	zset, err := valueAs[[]SortedSet](data, exists)
+	if err != nil {
+		return 0, err
 	}
 
 	appended := 0
-	zset := data.Value.([]SortedSet)
 	for _, member := range members {
 		found := false
 		for _, existing := range zset {
@@ -456,42 +660,33 @@ func (s *Store) ZAdd(key string, members []SortedSet) int {
 	})
 
 	data.Value = zset
-	s.data[key] = data
+	s.put(key, data)
 
-	return appended
+	return appended, nil
 }
 
-func (s *Store) ZRange(key string, start, end int) []SortedSet {
+func (s *Store) ZRange(key string, start, end int) ([]SortedSet, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	if _, exists := s.data[key]; !exists {
-		return nil
+	data, exists := s.lookup(key)
+	zset, err := valueAs[[]SortedSet](data, exists)
+	if err != nil {
+		return nil, err
 	}
 
-	zset := s.data[key].Value.([]SortedSet)
-	if start < 0 {
-		start = len(zset) + start
-	}
-	if end < 0 {
-		end = len(zset) + end
-	}
-	if start < 0 {
-		start = 0
-	}
-	if end >= len(zset) {
-		end = len(zset) - 1
-	}
-	return zset[start : end+1]
+	lo, hi := rangeBounds(start, end, len(zset))
+	return append([]SortedSet(nil), zset[lo:hi]...), nil
 }
 
-func (s *Store) ZRem(key string, members []string) int {
+func (s *Store) ZRem(key string, members []string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if _, exists := s.data[key]; !exists {
-		return 0
+	data, exists := s.lookupWrite(key)
+	zset, err := valueAs[[]SortedSet](data, exists)
+	if err != nil || zset == nil {
+		return 0, err
 	}
 
-	zset := s.data[key].Value.([]SortedSet)
 	count := 0
 	for _, member := range members {
 		for i := 0; i < len(zset); i++ {
@@ -503,23 +698,150 @@ func (s *Store) ZRem(key string, members []string) int {
 		}
 	}
 	if len(zset) == 0 {
-		delete(s.data, key)
+		s.remove(key)
 	} else {
-		s.data[key] = Data{
-			Value: zset,
-			TTL:   0,
+		data.Value = zset
+		s.put(key, data)
+	}
+	return count, nil
+}
+
+// Snapshot returns a deep copy of every key that has not expired yet, so it
+// can be serialized while writes continue against the store.
+func (s *Store) Snapshot() map[string]Data {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now().UnixMilli()
+	snapshot := make(map[string]Data, len(s.data))
+	for key, data := range s.data {
+		if data.expired(now) {
+			continue
+		}
+		snapshot[key] = Data{Value: copyValue(data.Value), TTL: data.TTL}
+	}
+	return snapshot
+}
+
+func copyValue(value any) any {
+	switch v := value.(type) {
+	case []string:
+		return append([]string(nil), v...)
+	case map[string]string:
+		copied := make(map[string]string, len(v))
+		for field, val := range v {
+			copied[field] = val
 		}
+		return copied
+	case map[string]struct{}:
+		copied := make(map[string]struct{}, len(v))
+		for member := range v {
+			copied[member] = struct{}{}
+		}
+		return copied
+	case []SortedSet:
+		return append([]SortedSet(nil), v...)
 	}
-	return count
+	return value
 }
 
-// Clear the store of all expired keys
-func (s *Store) ClearExpired() {
+// Restore replaces the contents of the store, e.g. with a loaded snapshot.
+func (s *Store) Restore(data map[string]Data) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	for key, data := range s.data {
-		if data.TTL > 0 && data.TTL < time.Now().Unix() {
-			delete(s.data, key)
-		}
+	s.data = make(map[string]Data, len(data))
+	s.keys = newKeyIndex()
+	s.expires = newKeyIndex()
+	s.used = 0
+	s.typeCounts = [len(typeNames)]int{}
+	for key, value := range data {
+		s.put(key, Data{Value: value.Value, TTL: value.TTL})
+	}
+}
+
+// Stats reports keyspace, expiration and memory counters.
+type Stats struct {
+	Keys    int
+	Expires int
+	// ExpiredKeys counts keys deleted because their TTL passed, whether
+	// found by the active cycle or on access.
+	ExpiredKeys int64
+	// ExpiredStalePerc estimates the percentage of keys with a TTL that
+	// have expired but not been deleted yet.
+	ExpiredStalePerc float64
+	// ExpiredTimeCapReachedCount counts active cycles that stopped because
+	// they ran out of time rather than out of expired keys.
+	ExpiredTimeCapReachedCount int64
+
+	UsedMemory     int64
+	UsedMemoryPeak int64
+	MaxMemory      int64
+	EvictionPolicy EvictionPolicy
+	EvictedKeys    int64
+
+	// KeyspaceHits and KeyspaceMisses count reads of existing and missing
+	// keys.
+	KeyspaceHits   int64
+	KeyspaceMisses int64
+
+	// KeysByType counts keys by the type of their value: string, list,
+	// hash, set and zset. Expired keys that were not deleted yet count.
+	KeysByType map[string]int
+}
+
+func (s *Store) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Stats{
+		Keys:                       len(s.data),
+		Expires:                    s.expires.len(),
+		ExpiredKeys:                s.expiredKeys,
+		ExpiredStalePerc:           s.expiredStalePerc,
+		ExpiredTimeCapReachedCount: s.expiredTimeCapReached,
+		UsedMemory:                 s.used,
+		UsedMemoryPeak:             s.peakUsed,
+		MaxMemory:                  s.maxMemory,
+		EvictionPolicy:             s.policy,
+		EvictedKeys:                s.evictedKeys,
+		KeyspaceHits:               s.keyspaceHits.Load(),
+		KeyspaceMisses:             s.keyspaceMisses.Load(),
+		KeysByType:                 s.keysByType(),
 	}
 }
+
+// typeNames are the types of values, as TYPE names them.
+var typeNames = [...]string{"string", "list", "hash", "set", "zset"}
+
+// typeOf returns the index of the type of value in typeNames.
+func typeOf(value any) int {
+	switch value.(type) {
+	case []string:
+		return 1
+	case map[string]string:
+		return 2
+	case map[string]struct{}:
+		return 3
+	case []SortedSet:
+		return 4
+	}
+	return 0
+}
+
+func (s *Store) keysByType() map[string]int {
+	counts := make(map[string]int, len(typeNames))
+	for i, name := range typeNames {
+		counts[name] = s.typeCounts[i]
+	}
+	return counts
+}
+
+// ResetStats zeroes the counters reported by Stats, as CONFIG RESETSTAT does.
+func (s *Store) ResetStats() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expiredKeys = 0
+	s.expiredTimeCapReached = 0
+	s.evictedKeys = 0
+	s.peakUsed = s.used
+	s.keyspaceHits.Store(0)
+	s.keyspaceMisses.Store(0)
+}